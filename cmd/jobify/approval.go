@@ -0,0 +1,118 @@
+package jobify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+func requiresApproval(deployment *appv1.Deployment) bool {
+	return deployment.Annotations[RequiresApprovalAnnotationKey] == "true"
+}
+
+// getApprovalState returns the approval state of a job, or an empty string for jobs that never
+// needed approval.
+func getApprovalState(job *batchv1.Job) string {
+	return job.Annotations[ApprovalAnnotationKey]
+}
+
+// setJobCreatorIdentity records who creates a job that needs approval, as verified by the API
+// server, since that's the identity approvers are compared with. The created-by annotation can come
+// from the git or OS user, which anyone can set. The job can't be created without it.
+func setJobCreatorIdentity(clientset *kubernetes.Clientset, job *batchv1.Job) error {
+	if getApprovalState(job) != ApprovalPending {
+		return nil
+	}
+	user := getClusterUser(clientset)
+	if user == "" {
+		return errors.New("the deployment requires approval, which needs the API server to verify who you are through a SelfSubjectReview (Kubernetes 1.27 and later)")
+	}
+	job.Annotations[CreatorIdentityAnnotationKey] = user
+	return nil
+}
+
+func approveJob(clientset *kubernetes.Clientset, job *batchv1.Job) {
+	approver := checkApprover(clientset, job)
+	suspend := false
+	patchApproval(clientset, job, ApprovalApproved, ApprovedByAnnotationKey, approver, &suspend)
+	fmt.Printf("Approved job %s/%s, it will start shortly\n", job.Namespace, job.Name)
+}
+
+func rejectJob(clientset *kubernetes.Clientset, job *batchv1.Job) {
+	rejecter := checkApprover(clientset, job)
+	patchApproval(clientset, job, ApprovalRejected, RejectedByAnnotationKey, rejecter, nil)
+	fmt.Printf("Rejected job %s/%s, it will stay suspended until it's deleted\n", job.Namespace, job.Name)
+}
+
+// checkApprover makes sure the job is waiting for approval and that the current user isn't the
+// one who created it, then returns the current user. Only the identity verified by the API server
+// is trusted, since the other ways of finding the user can be set to anything.
+func checkApprover(clientset *kubernetes.Clientset, job *batchv1.Job) string {
+	state := getApprovalState(job)
+	if state == "" {
+		fmt.Printf("Job %s/%s doesn't require approval\n", job.Namespace, job.Name)
+		os.Exit(1)
+	} else if state != ApprovalPending {
+		fmt.Printf("Job %s/%s was already %s\n", job.Namespace, job.Name, state)
+		os.Exit(1)
+	}
+
+	user := getClusterUser(clientset)
+	if user == "" {
+		fmt.Println("Couldn't verify who you are with the API server, jobs can only be approved or rejected on clusters that support SelfSubjectReview (1.27 and later)")
+		os.Exit(1)
+	}
+	creator, ok := job.Annotations[CreatorIdentityAnnotationKey]
+	if !ok {
+		fmt.Printf("Job %s/%s doesn't record the verified identity of its creator, it can't be approved or rejected\n", job.Namespace, job.Name)
+		os.Exit(1)
+	}
+	if user == creator {
+		fmt.Printf("Job %s/%s was created by %s, it must be approved or rejected by someone else\n", job.Namespace, job.Name, user)
+		os.Exit(1)
+	}
+	return user
+}
+
+func patchApproval(clientset *kubernetes.Clientset, job *batchv1.Job, state, userAnnotationKey, user string, suspend *bool) {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				ApprovalAnnotationKey: state,
+				userAnnotationKey:     user,
+			},
+			// The resource version makes the patch fail if someone else approved or rejected the
+			// job in the meantime
+			"resourceVersion": job.ResourceVersion,
+		},
+	}
+	if suspend != nil {
+		patch["spec"] = map[string]interface{}{
+			"suspend": *suspend,
+		}
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	_, err = clientset.BatchV1().Jobs(job.Namespace).Patch(context.TODO(), job.Name, types.MergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		fmt.Printf("Error updating job: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,230 @@
+package jobify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	LogArchiveAnnotationKey = "jobify/log-archive"
+	archivePollInterval     = 2 * time.Second
+)
+
+// logArchiver follows the logs of every container of a job's pods, including init containers and
+// restarts, into one file each.
+type logArchiver struct {
+	clientset *kubernetes.Clientset
+	dir       string
+	started   map[string]bool
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	errs      []error
+}
+
+// checkLogArchiveDestination fails early when the logs couldn't be uploaded, rather than once the
+// job has finished.
+func checkLogArchiveDestination(destination string) error {
+	if _, ok := parseS3Location(destination); ok {
+		_, err := newS3Client()
+		return err
+	} else if strings.HasPrefix(destination, "s3://") {
+		return fmt.Errorf("invalid location %s, it must be in the format s3://bucket[/prefix]", destination)
+	}
+	return nil
+}
+
+// archiveJobLogs follows the logs of a job until it finishes, then writes them to a local directory
+// or uploads them to an S3 location, and records where they are on the job.
+func archiveJobLogs(clientset *kubernetes.Clientset, job *batchv1.Job, destination string) {
+	location, files, err := saveJobArchive(clientset, job, destination, nil)
+	if err != nil {
+		fmt.Printf("Error archiving logs: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if files == 0 {
+		yellow.Println("No logs found! Pods were likely garbage collected")
+		return
+	}
+	fmt.Printf("Archived the logs of %d containers to %s\n", files, location)
+
+	if err := setJobLogArchive(clientset, job, location); err != nil {
+		yellow.Printf("Warning: couldn't record the archive on the job: %s\n", err.Error())
+	}
+}
+
+// saveJobArchive writes the logs of a job, along with the extra files, to
+// <destination>/<namespace>/<job-name>. It returns the location and the number of archived
+// containers. Nothing is written when there are neither logs nor extra files.
+func saveJobArchive(clientset *kubernetes.Clientset, job *batchv1.Job, destination string, extraFiles map[string][]byte) (string, int, error) {
+	s3Location, toS3 := parseS3Location(destination)
+	dir := filepath.Join(destination, job.Namespace, job.Name)
+	if toS3 {
+		tempDir, err := ioutil.TempDir("", "jobify-logs-")
+		if err != nil {
+			return "", 0, err
+		}
+		defer os.RemoveAll(tempDir)
+		dir = tempDir
+	}
+
+	a := &logArchiver{clientset: clientset, dir: dir, started: map[string]bool{}}
+	files, err := a.run(job)
+	if err != nil {
+		return "", 0, err
+	}
+	for _, err := range a.errs {
+		yellow.Printf("Warning: %s\n", err.Error())
+	}
+	if files == 0 && len(extraFiles) == 0 {
+		return "", 0, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, err
+	}
+	for name, data := range extraFiles {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return "", 0, err
+		}
+	}
+
+	if !toS3 {
+		location, err := filepath.Abs(dir)
+		return location, files, err
+	}
+	s3Location.Prefix = path.Join(s3Location.Prefix, job.Namespace, job.Name)
+	fmt.Printf("Uploading to %s...\n", s3Location.String())
+	return s3Location.String(), files, uploadLogArchive(dir, s3Location)
+}
+
+// run starts following every container as soon as it has started, until the job has finished and
+// all of the logs have been read. It returns the number of archived containers.
+func (a *logArchiver) run(job *batchv1.Job) (int, error) {
+	waiting := false
+	for {
+		latest, err := a.clientset.BatchV1().Jobs(job.Namespace).Get(context.TODO(), job.Name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		completed, failed := checkJobCondition(latest)
+		finished := completed || failed || getApprovalState(latest) == ApprovalRejected
+
+		podList, err := listJobPods(a.clientset, latest)
+		if err != nil {
+			return 0, err
+		}
+		for i := range podList.Items {
+			a.follow(&podList.Items[i])
+		}
+
+		// The pods were listed after the job finished, so every container has been seen
+		if finished {
+			break
+		}
+		if !waiting {
+			fmt.Println("Following the job's logs until it finishes...")
+			waiting = true
+		}
+		time.Sleep(archivePollInterval)
+	}
+	a.wg.Wait()
+	return len(a.started), nil
+}
+
+func (a *logArchiver) follow(pod *corev1.Pod) {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, s := range statuses {
+		if s.State.Running == nil && s.State.Terminated == nil {
+			continue
+		}
+		fileName := s.Name + ".log"
+		if s.RestartCount > 0 {
+			fileName = fmt.Sprintf("%s.%d.log", s.Name, s.RestartCount)
+		}
+		filePath := filepath.Join(a.dir, pod.Name, fileName)
+		if a.started[filePath] {
+			continue
+		}
+		a.started[filePath] = true
+
+		a.wg.Add(1)
+		go func(containerName string) {
+			defer a.wg.Done()
+			if err := streamContainerLogs(a.clientset, pod, containerName, filePath); err != nil {
+				a.mu.Lock()
+				a.errs = append(a.errs, fmt.Errorf("couldn't read the logs of %s/%s: %s", pod.Name, containerName, err.Error()))
+				a.mu.Unlock()
+			}
+		}(s.Name)
+	}
+}
+
+// streamContainerLogs writes the logs of a container to a file, following them until it exits.
+func streamContainerLogs(clientset *kubernetes.Clientset, pod *corev1.Pod, containerName, filePath string) error {
+	req := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: containerName,
+		Follow:    true,
+	})
+	podLogs, err := req.Stream(context.TODO())
+	if err != nil {
+		return err
+	}
+	defer podLogs.Close()
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, podLogs)
+	return err
+}
+
+func uploadLogArchive(dir string, location *S3Location) error {
+	client, err := newS3Client()
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relativePath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		key := path.Join(location.Prefix, filepath.ToSlash(relativePath))
+		return client.putObject(location.Bucket, key, data, "text/plain; charset=utf-8")
+	})
+}
+
+func setJobLogArchive(clientset *kubernetes.Clientset, job *batchv1.Job, location string) error {
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{LogArchiveAnnotationKey: location},
+		},
+	})
+	_, err := clientset.BatchV1().Jobs(job.Namespace).Patch(context.TODO(), job.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
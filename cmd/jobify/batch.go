@@ -0,0 +1,228 @@
+package jobify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	ItemPlaceholder        = "$JOBIFY_ITEM"
+	BatchIDLabelKey        = "jobify/batch-id"
+	BatchItemAnnotationKey = "jobify/batch-item"
+	DefaultBatchRate       = 2.0
+	MaxBatchRate           = 100.0
+)
+
+// readForEachItems reads one item per line from a text file, skipping empty lines and comments.
+func readForEachItems(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	items := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		items = append(items, line)
+	}
+	return items, scanner.Err()
+}
+
+// readForEachJSONItems reads the items of a JSON array. Strings are used as is, other values are
+// passed to the command as compact JSON.
+func readForEachJSONItems(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("%s must contain a JSON array: %s", path, err.Error())
+	}
+
+	items := []string{}
+	for _, v := range values {
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			items = append(items, s)
+			continue
+		}
+		compact, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, string(compact))
+	}
+	return items, nil
+}
+
+func checkForEachOptions(opts *JobOptions) []string {
+	if len(opts.ForEachItems) == 0 {
+		return []string{}
+	}
+	problems := []string{}
+	if !strings.Contains(opts.Command, ItemPlaceholder) {
+		problems = append(problems, fmt.Sprintf("The command must contain %s, which is replaced by each item", ItemPlaceholder))
+	}
+	if opts.ForEachRate > MaxBatchRate {
+		problems = append(problems, fmt.Sprintf("The rate can't be more than %g jobs per second", MaxBatchRate))
+	}
+	return problems
+}
+
+func getItemCommand(command, item string) string {
+	return strings.Replace(command, ItemPlaceholder, item, -1)
+}
+
+// createBatch creates one job per item, all sharing a batch ID. Every item's command goes through
+// the checks, and items that fail them or can't be created don't stop the others.
+func createBatch(clientset *kubernetes.Clientset, deployment *appv1.Deployment, opts *JobOptions, checks func(opts *JobOptions) (problems, warnings []string)) {
+	batchID := randomString(8)
+	rate := opts.ForEachRate
+	if rate <= 0 {
+		rate = DefaultBatchRate
+	}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+
+	fmt.Printf("Creating %d jobs in batch %s...\n", len(opts.ForEachItems), batchID)
+	failedItems := []string{}
+	for i, item := range opts.ForEachItems {
+		if i > 0 {
+			<-ticker.C
+		}
+		itemOpts := *opts
+		itemOpts.Command = getItemCommand(opts.Command, item)
+		itemOpts.ForEachItems = nil
+		if problems, _ := checks(&itemOpts); len(problems) > 0 {
+			red.Printf("Error creating the job for %s: %s\n", item, strings.Join(problems, "; "))
+			failedItems = append(failedItems, item)
+			continue
+		}
+		job := setupJob(deployment, &itemOpts)
+		job.Labels[BatchIDLabelKey] = batchID
+		job.Annotations[BatchItemAnnotationKey] = item
+
+		err := createJobExclusively(clientset, deployment, &itemOpts, printNotice, func() error {
+			_, err := createJobResource(clientset, job, &itemOpts)
+			if err == nil {
+				recordJobHistory(job, getContextName())
+			}
+			return err
+		})
+		if err != nil {
+			printConflicts(err)
+			red.Printf("Error creating the job for %s: %s\n", item, err.Error())
+			failedItems = append(failedItems, item)
+			continue
+		}
+		fmt.Printf("Created job %s/%s for %s\n", job.Namespace, job.Name, item)
+	}
+
+	fmt.Println()
+	fmt.Printf("Created %d of %d jobs in batch %s\n", len(opts.ForEachItems)-len(failedItems), len(opts.ForEachItems), batchID)
+	if len(failedItems) > 0 {
+		red.Printf("Jobs couldn't be created for: %s\n", strings.Join(failedItems, ", "))
+	}
+	faint.Println("Use the following command to view the batch's progress:")
+	cyan.Printf("jobify batch status %s\n", batchID)
+}
+
+func getBatchJobs(clientset *kubernetes.Clientset, batchID string) []batchv1.Job {
+	jobs, err := clientset.BatchV1().Jobs("").List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("jobify=true,%s=%s", BatchIDLabelKey, batchID),
+	})
+	if err != nil {
+		fmt.Printf("Error listing jobs: %s\n", err.Error())
+		os.Exit(1)
+	}
+	return jobs.Items
+}
+
+// getLatestBatchJobs returns the newest job of every item, since failed items may have been rerun.
+func getLatestBatchJobs(jobs []batchv1.Job) []*batchv1.Job {
+	latest := map[string]*batchv1.Job{}
+	for i := range jobs {
+		j := &jobs[i]
+		item := j.Annotations[BatchItemAnnotationKey]
+		if l, ok := latest[item]; !ok || l.CreationTimestamp.Before(&j.CreationTimestamp) {
+			latest[item] = j
+		}
+	}
+	result := []*batchv1.Job{}
+	for _, j := range latest {
+		result = append(result, j)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreationTimestamp.Before(&result[j].CreationTimestamp)
+	})
+	return result
+}
+
+func batchStatus(clientset *kubernetes.Clientset, batchID string, rerunFailed bool) {
+	fmt.Println("Loading jobs...")
+	jobs := getLatestBatchJobs(getBatchJobs(clientset, batchID))
+	if len(jobs) == 0 {
+		fmt.Printf("No jobs found in batch %s\n", batchID)
+		os.Exit(1)
+	}
+
+	counts := map[string]int{}
+	failedJobs := []*batchv1.Job{}
+	for _, j := range jobs {
+		state, icon := getJobState(j)
+		counts[state]++
+		if state == "Failed" {
+			failedJobs = append(failedJobs, j)
+		}
+		fmt.Printf("%s %s: ", icon, j.Annotations[BatchItemAnnotationKey])
+		cyan.Printf("%s/%s\n", j.Namespace, j.Name)
+	}
+
+	fmt.Println()
+	printAttribute("Batch", batchID)
+	printAttribute("Items", fmt.Sprint(len(jobs)))
+	states := []string{}
+	for state, count := range counts {
+		states = append(states, fmt.Sprintf("%s: %d", state, count))
+	}
+	sort.Strings(states)
+	printAttribute("States", strings.Join(states, ", "))
+
+	if len(failedJobs) == 0 || !rerunFailed {
+		if len(failedJobs) > 0 {
+			faint.Println("Use the following command to rerun the failed items:")
+			cyan.Printf("jobify batch status %s --rerun-failed\n", batchID)
+		}
+		return
+	}
+
+	fmt.Println()
+	fmt.Printf("Rerunning %d failed items...\n", len(failedJobs))
+	for _, j := range failedJobs {
+		newJob, err := rerunJob(clientset, j, printNotice)
+		if err != nil {
+			printConflicts(err)
+			red.Printf("Error rerunning %s: %s\n", j.Annotations[BatchItemAnnotationKey], err.Error())
+			continue
+		}
+		fmt.Printf("Created job %s/%s for %s\n", newJob.Namespace, newJob.Name, j.Annotations[BatchItemAnnotationKey])
+	}
+}
@@ -0,0 +1,274 @@
+package jobify
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	CollectAnnotationKey = "jobify/collect"
+	collectStateDir      = "/var/run/jobify"
+	collectExitCodeFile  = collectStateDir + "/exit-code"
+	collectReleaseFile   = collectStateDir + "/release"
+	collectTimeout       = time.Hour
+	collectPollInterval  = 2 * time.Second
+)
+
+// collectScript runs the command, then keeps the container alive until the artifacts have been
+// downloaded with jobify cp, or the timeout passes. Sidecars are terminated after it exits, when
+// that is enabled.
+var collectScript = fmt.Sprintf(`"$@"; rc=$?; echo $rc > %s; i=0; while [ ! -f %s ] && [ $i -lt %d ]; do sleep 2; i=$((i+2)); done; exit $rc`,
+	collectExitCodeFile, collectReleaseFile, int(collectTimeout.Seconds()))
+
+func checkCollectOptions(deployment *appv1.Deployment, opts *JobOptions) []string {
+	if opts.CollectPath == "" {
+		return []string{}
+	}
+	if !path.IsAbs(opts.CollectPath) {
+		return []string{"The --collect path must be absolute"}
+	}
+	primaryContainer := deployment.Spec.Template.Spec.Containers[getPrimaryContainer(deployment)]
+	for _, m := range primaryContainer.VolumeMounts {
+		if m.MountPath == opts.CollectPath || m.MountPath == collectStateDir {
+			return []string{fmt.Sprintf("Container %s already has a volume mounted at %s", primaryContainer.Name, m.MountPath)}
+		}
+	}
+	return []string{}
+}
+
+// setupJobCollect mounts a writable directory for the artifacts, and keeps the primary container
+// alive after the command exits so that they can be downloaded.
+func setupJobCollect(job *batchv1.Job, opts *JobOptions) {
+	if opts.CollectPath == "" {
+		return
+	}
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Volumes = append(podSpec.Volumes,
+		corev1.Volume{Name: "jobify-collect", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		corev1.Volume{Name: "jobify-collect-state", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	)
+	container := getJobPrimaryContainer(job)
+	container.VolumeMounts = append(container.VolumeMounts,
+		corev1.VolumeMount{Name: "jobify-collect", MountPath: opts.CollectPath},
+		corev1.VolumeMount{Name: "jobify-collect-state", MountPath: collectStateDir},
+	)
+	container.Command = append([]string{"sh", "-c", collectScript, "sh"}, container.Command...)
+	job.Annotations[CollectAnnotationKey] = opts.CollectPath
+}
+
+// parseCopySource parses "namespace/job-name:/path".
+func parseCopySource(source string) (namespace, name, remotePath string) {
+	colonIndex := strings.Index(source, ":")
+	if colonIndex == -1 || !path.IsAbs(source[colonIndex+1:]) {
+		fmt.Println("the source must be in the format \"namespace/job-name:/absolute/path\"")
+		os.Exit(1)
+	}
+	namespace, name = parseJobArgs([]string{source[:colonIndex]})
+	return namespace, name, path.Clean(source[colonIndex+1:])
+}
+
+// selectCopyPod returns the given pod of the job, or the newest one whose primary container is
+// running, since exec only works in running containers.
+func selectCopyPod(podList *corev1.PodList, containerName, podName string) (*corev1.Pod, error) {
+	pods := podList.Items
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.After(pods[j].CreationTimestamp.Time)
+	})
+	for i := range pods {
+		if podName != "" && pods[i].Name != podName {
+			continue
+		}
+		for _, s := range pods[i].Status.ContainerStatuses {
+			if s.Name == containerName && s.State.Running != nil {
+				return &pods[i], nil
+			}
+		}
+		if podName != "" {
+			return nil, fmt.Errorf("container %s of pod %s isn't running", containerName, podName)
+		}
+	}
+	if podName != "" {
+		return nil, fmt.Errorf("the job has no pod %s", podName)
+	}
+	return nil, fmt.Errorf("none of the job's pods has a running %s container, files can only be copied from running containers, use --collect to keep them running", containerName)
+}
+
+func execInContainer(clientset *kubernetes.Clientset, pod *corev1.Pod, containerName string, command []string, stdout, stderr io.Writer) error {
+	config, err := getClientConfig().ClientConfig()
+	if err != nil {
+		return err
+	}
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: containerName,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+	return executor.Stream(remotecommand.StreamOptions{Stdout: stdout, Stderr: stderr})
+}
+
+// waitForCollectedCommand waits until the command of a collecting job has exited, so that the
+// artifacts are complete, and returns its exit code.
+func waitForCollectedCommand(clientset *kubernetes.Clientset, pod *corev1.Pod, containerName string) (int, error) {
+	deadline := time.Now().Add(collectTimeout)
+	waiting := false
+	for {
+		stdout := &bytes.Buffer{}
+		err := execInContainer(clientset, pod, containerName, []string{"cat", collectExitCodeFile}, stdout, ioutil.Discard)
+		if err == nil {
+			return strconv.Atoi(strings.TrimSpace(stdout.String()))
+		} else if _, ok := err.(apierrors.APIStatus); ok {
+			// Only a missing exit code file means the command is still running, errors of the API
+			// server, e.g. not being allowed to exec into pods, won't go away by waiting
+			return 0, err
+		} else if time.Now().After(deadline) {
+			return 0, err
+		}
+		if !waiting {
+			fmt.Println("Waiting for the command to finish...")
+			waiting = true
+		}
+		time.Sleep(collectPollInterval)
+	}
+}
+
+func copyFromJob(clientset *kubernetes.Clientset, source, destination, podName string, keepAlive bool) {
+	namespace, name, remotePath := parseCopySource(source)
+	job := getJob(clientset, namespace, name)
+	containerName := job.Annotations[PrimaryContainerAnnotationKey]
+	pod, err := selectCopyPod(getJobPods(clientset, job), containerName, podName)
+	if err != nil {
+		fmt.Printf("Error copying files: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	collecting := job.Annotations[CollectAnnotationKey] != ""
+	if collecting {
+		exitCode, err := waitForCollectedCommand(clientset, pod, containerName)
+		if err != nil {
+			fmt.Printf("Error reading the command's exit code: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if exitCode != 0 {
+			yellow.Printf("The command exited with code %d, the files may be incomplete\n", exitCode)
+		}
+	}
+
+	target, err := filepath.Abs(getCopyDestination(destination, path.Base(remotePath)))
+	if err != nil {
+		fmt.Printf("Error copying files: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("Copying %s from pod %s...\n", remotePath, pod.Name)
+	reader, writer := io.Pipe()
+	stderr := &bytes.Buffer{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		command := []string{"tar", "cf", "-", "-C", path.Dir(remotePath), path.Base(remotePath)}
+		writer.CloseWithError(execInContainer(clientset, pod, containerName, command, writer, stderr))
+	}()
+	files, err := extractTar(reader, path.Base(remotePath), target)
+	// Unblocks the exec if the extraction stopped early
+	reader.CloseWithError(err)
+	<-done
+	if err != nil {
+		if stderr.Len() > 0 {
+			err = errors.New(strings.TrimSpace(stderr.String()))
+		}
+		fmt.Printf("Error copying files: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("Copied %d files to %s\n", files, target)
+
+	if collecting && !keepAlive {
+		err := execInContainer(clientset, pod, containerName, []string{"touch", collectReleaseFile}, ioutil.Discard, ioutil.Discard)
+		if err != nil {
+			fmt.Printf("Error releasing the pod: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("Released the pod, the job will finish shortly")
+	}
+}
+
+// getCopyDestination works like cp: copying into an existing directory keeps the source's name.
+func getCopyDestination(destination, baseName string) string {
+	if info, err := os.Stat(destination); err == nil && info.IsDir() {
+		return filepath.Join(destination, baseName)
+	}
+	return destination
+}
+
+// extractTar writes the entries of the archive, whose root is baseName, to the destination. Entries
+// that would end up outside of the destination are rejected.
+func extractTar(r io.Reader, baseName, destination string) (int, error) {
+	// Entries are compared with the destination, which mustn't have a trailing slash or dots
+	destination = filepath.Clean(destination)
+	files := 0
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return files, err
+		}
+
+		relativePath := strings.TrimPrefix(path.Clean(header.Name), baseName)
+		target := filepath.Join(destination, filepath.FromSlash(relativePath))
+		if target != destination && !strings.HasPrefix(target, destination+string(filepath.Separator)) {
+			return files, fmt.Errorf("the archive contains an invalid path %s", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return files, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return files, err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode)&0777)
+			if err != nil {
+				return files, err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return files, err
+			}
+			files++
+		default:
+			faint.Printf("Skipping %s, only regular files and directories are copied\n", header.Name)
+		}
+	}
+}
@@ -1,14 +1,19 @@
 package jobify
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	appv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
 )
 
 var Version string
@@ -19,78 +24,632 @@ const (
 )
 
 func SetupCommand() *cobra.Command {
+	createOpts := &JobOptions{}
+	var deadline time.Duration
+	var forEachFile, forEachJSONFile string
+	var createContexts []string
+	var nodeSelector, tolerations []string
+	createPlacement := &Placement{}
+	var volumes, files, createNotify []string
+	createPodOverrides := &PodOverrides{}
 	var cmdCreate = &cobra.Command{
 		Use:   "create",
 		Short: "Create a new job",
 		Args:  cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			create(getClient())
+			createOpts.DeadlineSeconds = int64(deadline.Seconds())
+			var err error
+			if forEachFile != "" {
+				createOpts.ForEachItems, err = readForEachItems(forEachFile)
+			} else if forEachJSONFile != "" {
+				createOpts.ForEachItems, err = readForEachJSONItems(forEachJSONFile)
+			}
+			if err != nil {
+				fmt.Printf("Error reading items: %s\n", err.Error())
+				os.Exit(1)
+			}
+			if createPlacement.NodeSelector, err = parseNodeSelector(strings.Join(nodeSelector, ",")); err != nil {
+				fmt.Printf("Invalid --node-selector: %s\n", err.Error())
+				os.Exit(1)
+			}
+			if createPlacement.Tolerations, err = parseTolerations(tolerations); err != nil {
+				fmt.Printf("Invalid --toleration: %s\n", err.Error())
+				os.Exit(1)
+			}
+			if !createPlacement.isEmpty() {
+				createOpts.Placement = createPlacement
+			}
+			if createOpts.Files, err = readJobFiles(files); err != nil {
+				fmt.Printf("Error reading --file: %s\n", err.Error())
+				os.Exit(1)
+			}
+			if createPodOverrides.Volumes, err = parseExtraVolumes(volumes); err != nil {
+				fmt.Printf("Invalid --volume: %s\n", err.Error())
+				os.Exit(1)
+			}
+			if !createPodOverrides.isEmpty() {
+				createOpts.PodOverrides = createPodOverrides
+			}
+			if createOpts.Notify, err = parseNotifyTargets(createNotify); err != nil {
+				fmt.Printf("Invalid --notify: %s\n", err.Error())
+				os.Exit(1)
+			}
+			if (createOpts.ArchiveLogs != "" || len(createOpts.Notify) > 0) && (len(createContexts) > 0 || len(createOpts.ForEachItems) > 0) {
+				fmt.Println("--archive-logs and --notify can't be combined with --contexts or --for-each")
+				os.Exit(1)
+			}
+			if createOpts.ArchiveLogs != "" {
+				if err := checkLogArchiveDestination(createOpts.ArchiveLogs); err != nil {
+					fmt.Printf("Invalid --archive-logs: %s\n", err.Error())
+					os.Exit(1)
+				}
+			}
+			if len(createContexts) > 0 {
+				createInContexts(getKubeContexts(createContexts, false), createOpts)
+				return
+			}
+			create(getClient(), createOpts)
 		},
 	}
+	cmdCreate.Flags().StringVar(&createOpts.Reason, "reason", "", "Why the job is being run, e.g. a ticket reference")
+	cmdCreate.Flags().BoolVar(&createOpts.Force, "force", false, "Create the job even if it conflicts with active jobs of the deployment")
+	cmdCreate.Flags().Int32Var(&createOpts.Parallelism, "parallelism", 0, "Maximum number of pods running at the same time")
+	cmdCreate.Flags().Int32Var(&createOpts.Completions, "completions", 0, "Number of pods that must succeed for the job to complete")
+	cmdCreate.Flags().BoolVar(&createOpts.Indexed, "indexed", false, "Give every completion an index, available to the command as $JOBIFY_INDEX")
+	cmdCreate.Flags().DurationVar(&deadline, "deadline", 0, "How long the job may run before it's terminated (default 24h)")
+	cmdCreate.Flags().StringVar(&forEachFile, "for-each", "", "Create one job per line of a file, replacing $JOBIFY_ITEM in the command")
+	cmdCreate.Flags().StringVar(&forEachJSONFile, "for-each-json", "", "Create one job per element of a JSON array, replacing $JOBIFY_ITEM in the command")
+	cmdCreate.Flags().Float64Var(&createOpts.ForEachRate, "rate", DefaultBatchRate, "Maximum number of jobs created per second with --for-each")
+	cmdCreate.Flags().StringSliceVar(&nodeSelector, "node-selector", nil, "Node labels the job's pods must run on, replacing the deployment's node selector, e.g. pool=batch")
+	cmdCreate.Flags().StringArrayVar(&tolerations, "toleration", nil, "Taint the job's pods tolerate, in the format key[=value][:effect], can be repeated")
+	cmdCreate.Flags().StringVar(&createPlacement.PriorityClass, "priority-class", "", "Priority class of the job's pods")
+	cmdCreate.Flags().BoolVar(&createPlacement.StripAntiAffinity, "strip-anti-affinity", false, "Remove the pod anti-affinity inherited from the deployment")
+	cmdCreate.Flags().StringSliceVar(&createOpts.DropContainers, "drop-container", nil, "Leave a container of the deployment, e.g. a sidecar, out of the job")
+	cmdCreate.Flags().BoolVar(&createOpts.TerminateSidecars, "terminate-sidecars", false, "Terminate the other containers once the command exits, so the job can complete (requires sh in the image)")
+	cmdCreate.Flags().StringSliceVar(&createPodOverrides.DropInitContainers, "drop-init-container", nil, "Leave an init container of the deployment out of the job, * drops all of them")
+	cmdCreate.Flags().StringSliceVar(&createPodOverrides.KeepInitContainers, "keep-init-container", nil, "Only keep the given init containers of the deployment")
+	cmdCreate.Flags().StringArrayVar(&volumes, "volume", nil, "Extra volume for the command, emptyDir:/mount/path[:size-limit] or pvc:claim-name:/mount/path[:ro], can be repeated")
+	cmdCreate.Flags().StringVar(&createPodOverrides.ServiceAccount, "service-account", "", "Service account of the job's pods, instead of the deployment's")
+	cmdCreate.Flags().StringVar(&createOpts.CollectPath, "collect", "", "Directory the command writes files to, the pod waits until they're downloaded with jobify cp (requires sh in the image)")
+	cmdCreate.Flags().StringArrayVar(&files, "file", nil, "Local file to upload into the job, local-path[:remote-path], by default into $JOBIFY_FILES_DIR ("+DefaultFilesDir+"), can be repeated")
+	cmdCreate.Flags().BoolVar(&createOpts.SecretFiles, "secret-files", false, "Upload the files into a Secret instead of a ConfigMap")
+	cmdCreate.Flags().StringVar(&createOpts.ArchiveLogs, "archive-logs", "", "Follow the job's logs until it finishes and save them to a directory or an s3://bucket/prefix location")
+	cmdCreate.Flags().StringSliceVar(&createNotify, "notify", nil, "Wait for the job and notify when it finishes: bell, desktop, webhook=URL or slack=URL, can be combined")
+	cmdCreate.Flags().StringSliceVar(&createContexts, "contexts", nil, "Create the same job in several kubeconfig contexts, e.g. --contexts eu,us")
 
+	var pendingOnly, allContexts bool
+	var listContexts []string
 	var cmdList = &cobra.Command{
 		Use:   "list",
 		Short: "List jobs and view the details of one",
 		Args:  cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			list(getClient())
+			if allContexts || len(listContexts) > 0 {
+				listInContexts(getKubeContexts(listContexts, allContexts), pendingOnly)
+				return
+			}
+			list(getClient(), pendingOnly)
 		},
 	}
+	cmdList.Flags().BoolVar(&pendingOnly, "pending-approval", false, "Only list jobs that are waiting for approval")
+	cmdList.Flags().BoolVar(&allContexts, "all-contexts", false, "List the jobs of every kubeconfig context")
+	cmdList.Flags().StringSliceVar(&listContexts, "contexts", nil, "List the jobs of several kubeconfig contexts, e.g. --contexts eu,us")
 
+	var watch bool
+	var viewOutput string
 	var cmdView = &cobra.Command{
 		Use:   "view {namespace job-name OR namespace/job-name}",
 		Short: "View the details of a job",
 		Args:  cobra.RangeArgs(1, 2),
 		Run: func(cmd *cobra.Command, args []string) {
 			clientset := getClient()
-			fmt.Println("Loading job...")
-			var namespace string
-			var name string
-			if len(args) == 2 {
-				namespace = args[0]
-				name = args[1]
-			} else if strings.Contains(args[0], "/") {
-				slashIndex := strings.Index(args[0], "/")
-				namespace = args[0][0:slashIndex]
-				name = args[0][slashIndex+1:]
-			} else {
-				fmt.Println("job details must be provided in one of two formats \"namespace job-name\" or \"namespace/job-name\"")
-				os.Exit(1)
+			output := getOutputFormat(viewOutput)
+			if output != OutputText {
+				namespace, name := parseJobArgs(args)
+				printJobObject(getJob(clientset, namespace, name), output)
+				return
 			}
+			fmt.Println("Loading job...")
+			namespace, name := parseJobArgs(args)
 			job := getJob(clientset, namespace, name)
+			if watch {
+				watchJob(clientset, job)
+				return
+			}
 			viewJob(clientset, job)
 		},
 	}
+	cmdView.Flags().BoolVarP(&watch, "watch", "w", false, "Keep updating the job's details until it finishes")
+	cmdView.Flags().StringVarP(&viewOutput, "output", "o", "", "Output format: text, json or yaml (default from the config, or text)")
+
+	var cmdDashboard = &cobra.Command{
+		Use:   "dashboard",
+		Short: "Open a full-screen dashboard of all jobs",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runDashboard(getClient())
+		},
+	}
+	var historyLimit int
+	var cmdHistory = &cobra.Command{
+		Use:   "history [search terms]",
+		Short: "List the jobs you created, optionally filtered by search terms",
+		Args:  cobra.ArbitraryArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			history(args, historyLimit)
+		},
+	}
+	cmdHistory.Flags().IntVarP(&historyLimit, "limit", "n", 20, "Maximum number of jobs to list, 0 lists all of them")
+
+	var rerunForce bool
+	var cmdHistoryRerun = &cobra.Command{
+		Use:   "rerun {id}",
+		Short: "Create a job again from a history entry",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			rerunHistoryEntry(args[0], rerunForce)
+		},
+	}
+	cmdHistoryRerun.Flags().BoolVar(&rerunForce, "force", false, "Create the job even if it conflicts with active jobs of the deployment")
+	cmdHistory.AddCommand(cmdHistoryRerun)
+
+	var cmdApprove = &cobra.Command{
+		Use:   "approve {namespace job-name OR namespace/job-name}",
+		Short: "Approve a job that is waiting for approval, allowing it to start",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			clientset := getClient()
+			namespace, name := parseJobArgs(args)
+			approveJob(clientset, getJob(clientset, namespace, name))
+		},
+	}
+
+	var cmdReject = &cobra.Command{
+		Use:   "reject {namespace job-name OR namespace/job-name}",
+		Short: "Reject a job that is waiting for approval, it will never start",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			clientset := getClient()
+			namespace, name := parseJobArgs(args)
+			rejectJob(clientset, getJob(clientset, namespace, name))
+		},
+	}
+
+	var cmdBatch = &cobra.Command{
+		Use:   "batch",
+		Short: "Work with batches of jobs created with --for-each",
+	}
+
+	var rerunFailed bool
+	var cmdBatchStatus = &cobra.Command{
+		Use:   "status {batch-id}",
+		Short: "View the progress of a batch of jobs",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			batchStatus(getClient(), args[0], rerunFailed)
+		},
+	}
+	cmdBatchStatus.Flags().BoolVar(&rerunFailed, "rerun-failed", false, "Create the jobs of the failed items again")
+	cmdBatch.AddCommand(cmdBatchStatus)
+
+	var cmdPipeline = &cobra.Command{
+		Use:   "pipeline",
+		Short: "Run sequences of jobs that depend on each other",
+	}
+
+	var pipelineFile string
+	var pipelineForce bool
+	var cmdPipelineRun = &cobra.Command{
+		Use:   "run",
+		Short: "Run the steps of a pipeline file, waiting for each job to finish",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			pipeline, err := readPipeline(pipelineFile)
+			if err != nil {
+				fmt.Printf("Error reading pipeline: %s\n", err.Error())
+				os.Exit(1)
+			}
+			runPipeline(getClient(), pipeline, pipelineForce)
+		},
+	}
+	cmdPipelineRun.Flags().StringVarP(&pipelineFile, "file", "f", "", "Pipeline file (YAML)")
+	cmdPipelineRun.Flags().BoolVar(&pipelineForce, "force", false, "Create the jobs even if they conflict with active jobs of their deployments")
+	_ = cmdPipelineRun.MarkFlagRequired("file")
+
+	var cmdPipelineStatus = &cobra.Command{
+		Use:   "status {run-id}",
+		Short: "View the steps of a pipeline run",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			pipelineStatus(getClient(), args[0])
+		},
+	}
+	cmdPipeline.AddCommand(cmdPipelineRun, cmdPipelineStatus)
+
+	var applyFile string
+	var applyForce, applyDryRun bool
+	var cmdApply = &cobra.Command{
+		Use:   "apply",
+		Short: "Create a job from a spec file",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			apply(getClient(), applyFile, applyForce, applyDryRun)
+		},
+	}
+	cmdApply.Flags().StringVarP(&applyFile, "file", "f", "", "Spec file (YAML)")
+	cmdApply.Flags().BoolVar(&applyForce, "force", false, "Create the job even if it conflicts with active jobs of the deployment")
+	cmdApply.Flags().BoolVar(&applyDryRun, "dry-run", false, "Print the job instead of creating it")
+	_ = cmdApply.MarkFlagRequired("file")
+
+	var initFile string
+	var cmdInit = &cobra.Command{
+		Use:   "init {namespace job-name OR namespace/job-name}",
+		Short: "Write a spec file that reproduces an existing job",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			clientset := getClient()
+			namespace, name := parseJobArgs(args)
+			initTaskSpec(getJob(clientset, namespace, name), initFile)
+		},
+	}
+	cmdInit.Flags().StringVarP(&initFile, "file", "f", "", "File to write the spec to, instead of printing it")
+
+	var copyPod string
+	var copyKeepAlive bool
+	var cmdCopy = &cobra.Command{
+		Use:   "cp {namespace/job-name:/path} {local-path}",
+		Short: "Copy files from a job's pod to the local machine",
+		Long: `Copy files from a job's pod to the local machine. The files are copied from the primary
+container with tar, which must be available in the image. For jobs created with --collect, the
+copy waits for the command to finish, then lets the pod exit.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			copyFromJob(getClient(), args[0], args[1], copyPod, copyKeepAlive)
+		},
+	}
+	cmdCopy.Flags().StringVarP(&copyPod, "pod", "p", "", "Pod to copy from, instead of the newest running one")
+	cmdCopy.Flags().BoolVar(&copyKeepAlive, "keep-alive", false, "Keep the pod of a --collect job waiting, to copy more files later")
+
+	var watchNotify []string
+	var cmdWatch = &cobra.Command{
+		Use:   "watch {namespace job-name OR namespace/job-name}",
+		Short: "Wait for a job to finish, optionally sending notifications",
+		Long: `Wait for a job to finish, optionally sending notifications. It exits with an error when the
+job fails or is rejected, so it can be used in scripts.
+
+Notifications:
+  bell         ring the terminal bell
+  desktop      show a desktop notification through notify-send
+  webhook=URL  POST a JSON payload with the job's state, duration and links
+  slack=URL    POST a Slack-compatible message, e.g. to an incoming webhook`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			targets, err := parseNotifyTargets(watchNotify)
+			if err != nil {
+				fmt.Printf("Invalid --notify: %s\n", err.Error())
+				os.Exit(1)
+			}
+			clientset := getClient()
+			namespace, name := parseJobArgs(args)
+			waitAndNotify(clientset, getJob(clientset, namespace, name), targets)
+		},
+	}
+	cmdWatch.Flags().StringSliceVar(&watchNotify, "notify", nil, "Notifications to send when the job finishes: bell, desktop, webhook=URL or slack=URL, can be combined")
+
+	gcOpts := &GCOptions{}
+	var cmdGC = &cobra.Command{
+		Use:   "gc",
+		Short: "Delete old jobify jobs",
+		Long: `Delete old jobify jobs, along with their pods. Only finished jobs are deleted: completed, failed,
+cancelled and rejected ones. Without --confirm, the jobs are only listed.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := checkGCOptions(gcOpts); err != nil {
+				fmt.Printf("Invalid options: %s\n", err.Error())
+				os.Exit(1)
+			}
+			if !cmd.Flags().Changed("namespace") {
+				gcOpts.Namespace = userConfig.Namespace
+			}
+			gc(getClient(), gcOpts)
+		},
+	}
+	cmdGC.Flags().StringVarP(&gcOpts.Namespace, "namespace", "n", "", "Only delete jobs of a namespace (default from the config, or all namespaces)")
+	cmdGC.Flags().DurationVar(&gcOpts.OlderThan, "older-than", 0, "Only delete jobs created longer ago than this, e.g. 168h")
+	cmdGC.Flags().StringSliceVar(&gcOpts.States, "state", nil, "Only delete jobs in these states: "+strings.Join(gcStates, ", ")+" (default all of them)")
+	cmdGC.Flags().StringSliceVar(&gcOpts.Deployments, "deployment", nil, "Only delete jobs created from these deployments, by name or alias")
+	cmdGC.Flags().IntVar(&gcOpts.KeepLast, "keep-last", 0, "Keep the newest N jobs of every deployment")
+	cmdGC.Flags().StringVar(&gcOpts.Archive, "archive", "", "Save the spec and logs of every job to a directory or an s3://bucket/prefix location before deleting it")
+	cmdGC.Flags().BoolVar(&gcOpts.Confirm, "confirm", false, "Delete the jobs, instead of only listing them")
+
+	var exporterAddress, exporterNamespace string
+	var exporterInCluster bool
+	var cmdExporter = &cobra.Command{
+		Use:   "exporter",
+		Short: "Serve Prometheus metrics of jobify jobs",
+		Long: `Serve Prometheus metrics of jobify jobs on /metrics: the jobs created per deployment and user, the
+jobs that finished per state, their durations and the jobs that are currently active. Run it with
+--in-cluster in a pod whose service account can list and watch jobs.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !cmd.Flags().Changed("namespace") {
+				exporterNamespace = userConfig.Namespace
+			}
+			runExporter(getServiceClient(exporterInCluster), exporterNamespace, exporterAddress)
+		},
+	}
+	cmdExporter.Flags().StringVar(&exporterAddress, "listen", ":9090", "Address to serve the metrics on")
+	cmdExporter.Flags().StringVarP(&exporterNamespace, "namespace", "n", "", "Only watch the jobs of a namespace (default from the config, or all namespaces)")
+	cmdExporter.Flags().BoolVar(&exporterInCluster, "in-cluster", false, "Use the pod's service account instead of the kubeconfig")
+
+	var serveAddress, serveNamespace, serveTokenFile string
+	var serveInCluster, serveTrustUserHeader bool
+	var cmdServe = &cobra.Command{
+		Use:   "serve",
+		Short: "Serve an HTTP API to list deployments and create, view and cancel jobs",
+		Long: `Serve an HTTP API to list deployments and create, view and cancel jobs. Every request needs the
+token as a bearer token, from --token-file or ` + APITokenEnvVar + `. Jobs go through the same policies and
+checks as jobify apply, and are created by ` + defaultAPIUser + `. Behind a proxy that authenticates users and
+sets the ` + APIUserHeader + ` header itself, --trust-user-header creates them by the user in the header
+instead. Anyone with the token can set the header, so don't use it when clients reach the API directly.
+
+  GET  /api/v1/deployments                       deployments with their presets
+  GET  /api/v1/jobs[?namespace=]                 jobs, newest first
+  POST /api/v1/jobs                              create a job from a task spec, with force and dryRun
+  GET  /api/v1/jobs/{namespace}/{name}           job details and pods
+  GET  /api/v1/jobs/{namespace}/{name}/logs      server-sent events, ?pod=&container=&follow=false
+  POST /api/v1/jobs/{namespace}/{name}/cancel    cancel a job
+
+Errors are JSON objects: {"error": {"code": "...", "message": "...", "details": [...]}}`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !cmd.Flags().Changed("namespace") {
+				serveNamespace = userConfig.Namespace
+			}
+			serve(getServiceClient(serveInCluster), serveNamespace, serveAddress, serveTokenFile, serveTrustUserHeader)
+		},
+	}
+	cmdServe.Flags().StringVar(&serveAddress, "listen", ":8080", "Address to serve the API on")
+	cmdServe.Flags().StringVarP(&serveNamespace, "namespace", "n", "", "Only serve deployments and jobs of a namespace (default from the config, or all namespaces)")
+	cmdServe.Flags().StringVar(&serveTokenFile, "token-file", "", "File containing the bearer token, instead of "+APITokenEnvVar)
+	cmdServe.Flags().BoolVar(&serveInCluster, "in-cluster", false, "Use the pod's service account instead of the kubeconfig")
+	cmdServe.Flags().BoolVar(&serveTrustUserHeader, "trust-user-header", false, "Create jobs by the user in the "+APIUserHeader+" header, only behind an authenticating proxy")
+
+	var linkName string
+	var cmdOpen = &cobra.Command{
+		Use:   "open {namespace job-name OR namespace/job-name}",
+		Short: "Open one of a job's links, e.g. its logs, in the browser",
+		Long: `Open one of a job's links, e.g. its logs, in the browser. The links come from the deployment's
+` + LogsURLTemplateAnnotationKey + ` annotation, which is the logs link, and its ` + LinkTemplatesAnnotationKey + `
+annotation, a JSON object of named templates, e.g. {"metrics": "...", "traces": "..."}.
+
+The templates can use these placeholders, whose values are URL-encoded:
+  $JOB, $NAMESPACE, $CONTAINER, $DEPLOYMENT, $CONTEXT, $CLUSTER
+  $POD (the newest pod), $PODS (all pods, comma-separated)
+  $CREATED, $COMPLETED (RFC3339), $CREATED_MS, $COMPLETED_MS (Unix epoch in milliseconds)
+The completion time of an unfinished job is the current time.`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			clientset := getClient()
+			namespace, name := parseJobArgs(args)
+			openJobLink(clientset, getJob(clientset, namespace, name), linkName)
+		},
+	}
+	cmdOpen.Flags().StringVarP(&linkName, "link", "l", LogsLinkName, "Name of the link to open")
+
+	var logsDestination string
+	var cmdLogs = &cobra.Command{
+		Use:   "logs {namespace job-name OR namespace/job-name} --save {directory OR s3://bucket/prefix}",
+		Short: "Archive the logs of every container of a job",
+		Long: `Archive the logs of every container of a job, following them until the job finishes, so that
+they're kept after the pods are garbage collected. The logs are written to
+<destination>/<namespace>/<job-name>/<pod>/<container>.log, and the location is recorded on the job
+for jobify view.
+
+S3 locations use the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_REGION environment variables.
+Set ` + S3EndpointEnvVar + ` to upload to another S3-compatible store, e.g. http://localhost:9000 for MinIO.`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := checkLogArchiveDestination(logsDestination); err != nil {
+				fmt.Printf("Invalid --save: %s\n", err.Error())
+				os.Exit(1)
+			}
+			clientset := getClient()
+			namespace, name := parseJobArgs(args)
+			archiveJobLogs(clientset, getJob(clientset, namespace, name), logsDestination)
+		},
+	}
+	cmdLogs.Flags().StringVar(&logsDestination, "save", "", "Directory or s3://bucket/prefix location to save the logs to")
+	_ = cmdLogs.MarkFlagRequired("save")
+
+	var cmdPolicy = &cobra.Command{
+		Use:   "policy",
+		Short: "Work with the policies that restrict which jobs can be created",
+		Long: `Work with the policies that restrict which jobs can be created. The cluster policy is the ` + PolicyConfigMapKey + `
+key of the ConfigMap ` + PolicyConfigMapNamespace + `/` + PolicyConfigMapName + `, and deployments can add their own in the
+` + PolicyAnnotationKey + ` annotation. Every user who creates jobs needs get on that ConfigMap, e.g. through a Role
+in ` + PolicyConfigMapNamespace + ` with resourceNames: [` + PolicyConfigMapName + `] bound to them, otherwise jobs can't be created.`,
+	}
+
+	policyTestOpts := &JobOptions{}
+	var policyTestDeadline time.Duration
+	var policyFile string
+	var cmdPolicyTest = &cobra.Command{
+		Use:   "test {namespace/deployment-name} {command}",
+		Short: "Check whether a command is allowed by a deployment's policies without creating a job",
+		Args:  cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			namespace, name := parseDeploymentArg(args[0])
+			policyTestOpts.Command = strings.Join(args[1:], " ")
+			policyTestOpts.DeadlineSeconds = int64(policyTestDeadline.Seconds())
+			policyTest(namespace, name, policyTestOpts, policyFile)
+		},
+	}
+	cmdPolicyTest.Flags().StringVar(&policyTestOpts.ImageTagOverride, "image-tag", "", "Image tag override to check")
+	cmdPolicyTest.Flags().DurationVar(&policyTestDeadline, "deadline", 0, "Job deadline to check (default 24h)")
+	cmdPolicyTest.Flags().StringVarP(&policyFile, "file", "f", "", "Evaluate a local policy file instead of the cluster's policies")
+	cmdPolicy.AddCommand(cmdPolicyTest)
+
+	var cmdConfig = &cobra.Command{
+		Use:   "config",
+		Short: "Work with the defaults in " + getConfigPath(),
+	}
+
+	var cmdConfigView = &cobra.Command{
+		Use:   "view",
+		Short: "Print the config",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			configView()
+		},
+	}
+
+	var cmdConfigGet = &cobra.Command{
+		Use:   "get {key}",
+		Short: "Print the value of a config key",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			configGet(args[0])
+		},
+	}
+
+	var cmdConfigSet = &cobra.Command{
+		Use:   "set {key} {value}",
+		Short: "Set a config key, an empty value resets it",
+		Long: `Set a config key, an empty value resets it. The keys are:
+  context         Kubernetes context to use instead of the current one
+  namespace       Namespace to list deployments and jobs from, and to use when only a job name is given
+  favorites       Comma-separated namespace/deployment-name list, pinned to the top when creating jobs
+  aliases.<name>  Command that replaces <name> when it's the first word of a command
+  output          Default output format of view: text, json or yaml
+  color           Whether to use colors, true or false
+  emoji           Whether to use emoji, true or false
+  logTailLines    Number of log lines shown by view --watch and the dashboard`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			configSet(args[0], args[1])
+		},
+	}
+	cmdConfig.AddCommand(cmdConfigView, cmdConfigGet, cmdConfigSet)
+
 	var rootCmd = &cobra.Command{
 		Use: "jobify",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			loadConfig()
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			jobifyRoot()
 		},
 		Version: Version,
 	}
+	rootCmd.PersistentFlags().StringVar(&kubeContext, "context", "", "Kubernetes context to use instead of the current one")
 
-	rootCmd.AddCommand(cmdCreate, cmdList, cmdView)
+	rootCmd.AddCommand(cmdCreate, cmdList, cmdView, cmdDashboard, cmdHistory, cmdApprove, cmdReject, cmdPolicy, cmdBatch, cmdPipeline, cmdApply, cmdInit, cmdConfig, cmdCopy, cmdLogs, cmdOpen, cmdWatch, cmdGC, cmdExporter, cmdServe)
 	return rootCmd
 
 }
 
+func parseJobArgs(args []string) (namespace, name string) {
+	if len(args) == 2 {
+		return args[0], args[1]
+	} else if strings.Contains(args[0], "/") {
+		slashIndex := strings.Index(args[0], "/")
+		return args[0][0:slashIndex], args[0][slashIndex+1:]
+	} else if userConfig.Namespace != "" {
+		return userConfig.Namespace, args[0]
+	}
+	fmt.Println("job details must be provided in one of two formats \"namespace job-name\" or \"namespace/job-name\"")
+	os.Exit(1)
+	return "", ""
+}
+
+// parseDeploymentArg parses "namespace/deployment-name", or only the deployment's name when the
+// user config has a default namespace.
+func parseDeploymentArg(arg string) (namespace, name string) {
+	if strings.Contains(arg, "/") {
+		slashIndex := strings.Index(arg, "/")
+		return arg[0:slashIndex], arg[slashIndex+1:]
+	} else if userConfig.Namespace != "" {
+		return userConfig.Namespace, arg
+	}
+	fmt.Println("the deployment must be provided in the format \"namespace/deployment-name\"")
+	os.Exit(1)
+	return "", ""
+}
+
+// getJobChecks returns the checks a job has to pass before it can be created from a deployment.
+// Problems prevent the job's creation, while warnings only need to be acknowledged.
+func getJobChecks(clientset *kubernetes.Clientset, deployment *appv1.Deployment) func(opts *JobOptions) (problems, warnings []string) {
+	checks, err := loadJobChecks(clientset, deployment)
+	if err != nil {
+		fmt.Printf("Error loading policies: %s\n", err.Error())
+		os.Exit(1)
+	}
+	return checks
+}
+
+func loadJobChecks(clientset *kubernetes.Clientset, deployment *appv1.Deployment) (func(opts *JobOptions) (problems, warnings []string), error) {
+	policies, err := loadPolicies(clientset, deployment)
+	if err != nil {
+		return nil, err
+	}
+	// Jobs that need approval record who created them as verified by the API server
+	verified := !requiresApproval(deployment) || getClusterUser(clientset) != ""
+	return func(opts *JobOptions) (problems, warnings []string) {
+		warnings = append(warnings, checkSidecarWarnings(deployment, opts)...)
+		problems = evaluatePolicies(policies, deployment, opts)
+		if !verified {
+			problems = append(problems, "The deployment requires approval, which needs the API server to verify who you are through a SelfSubjectReview (Kubernetes 1.27 and later)")
+		}
+		problems = append(problems, checkCommandTemplate(deployment, opts)...)
+		problems = append(problems, checkCompletionOptions(opts)...)
+		problems = append(problems, checkForEachOptions(opts)...)
+		problems = append(problems, checkSidecarOptions(deployment, opts)...)
+		problems = append(problems, checkPodOverrides(deployment, opts)...)
+		problems = append(problems, checkCollectOptions(deployment, opts)...)
+		problems = append(problems, checkFileOptions(opts)...)
+		conflicts, err := checkConcurrency(clientset, deployment, opts)
+		if err != nil {
+			warnings = append(warnings, "Couldn't check for conflicting jobs: "+err.Error())
+		} else if opts.Force {
+			warnings = append(warnings, conflicts...)
+		} else {
+			problems = append(problems, conflicts...)
+		}
+		return problems, warnings
+	}, nil
+}
+
+// submitJob creates a job once no other user is creating a job from the same deployment.
+func submitJob(clientset *kubernetes.Clientset, deployment *appv1.Deployment, opts *JobOptions, job *batchv1.Job) {
+	err := createJobExclusively(clientset, deployment, opts, printNotice, func() error {
+		return createJob(clientset, job, opts)
+	})
+	if err != nil {
+		printConflicts(err)
+		fmt.Printf("Error creating job: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
 func jobifyRoot() {
 	faint.Println("No command given, starting in interactive mode...")
 	clientset := getClient()
 	i := promptOperation()
 	switch i {
 	case CreateJobIndex:
-		create(clientset)
+		create(clientset, &JobOptions{})
 	case ViewJobsIndex:
-		list(clientset)
+		list(clientset, false)
 	default:
 		panic(fmt.Sprintf("Unexpected operation index %d", i))
 	}
 }
 
-func create(clientset *kubernetes.Clientset) {
+func create(clientset *kubernetes.Clientset, opts *JobOptions) {
 	fmt.Println("Loading deployments...")
 	deploymentList := getJobifyDeployments(clientset)
+	sortFavoriteDeployments(deploymentList.Items)
 
 	i := promptDeploymentSelection(deploymentList)
 
@@ -104,35 +663,90 @@ func create(clientset *kubernetes.Clientset) {
 
 	defaultCommand := deployment.Annotations[DefaultCommandAnnotationKey]
 	fmt.Println()
-	userCommand := promptCommand(defaultCommand)
+	opts.Command = expandCommandAlias(promptCommand(defaultCommand))
+	opts.CreatedBy = getCurrentUser(clientset)
 
-	confirmed, imageTagOverride, userCommand := promptConfirmation(deployment, "", userCommand)
+	checks := getJobChecks(clientset, deployment)
+	confirmed := promptConfirmation(deployment, opts, checks)
 	if !confirmed {
 		fmt.Println("Cancelled job creation, terminating...")
 		return
 	}
 
-	commandArray := setupCommandArray(deployment, userCommand)
+	if len(opts.ForEachItems) > 0 {
+		createBatch(clientset, deployment, opts, checks)
+		return
+	}
+
+	job := setupJob(deployment, opts)
 
-	job := setupJob(deployment, commandArray, imageTagOverride, userCommand)
+	submitJob(clientset, deployment, opts, job)
 
-	createJob(clientset, job)
+	if opts.ArchiveLogs != "" {
+		fmt.Println()
+		archiveJobLogs(clientset, job, opts.ArchiveLogs)
+	}
+	if len(opts.Notify) > 0 {
+		fmt.Println()
+		waitAndNotify(clientset, job, opts.Notify)
+	}
 }
 
-func list(clientset *kubernetes.Clientset) {
+func list(clientset *kubernetes.Clientset, pendingOnly bool) {
 	fmt.Println("Loading jobs...")
 	jobList := getJobifyJobs(clientset)
+	jobList.Items = filterJobs(jobList.Items, pendingOnly)
+	if len(jobList.Items) == 0 {
+		fmt.Println("No jobs found")
+		return
+	}
 	sort.Slice(jobList.Items, func(i, j int) bool {
 		return jobList.Items[i].CreationTimestamp.UnixNano() > jobList.Items[j].CreationTimestamp.UnixNano()
 	})
-	i := promptJobSelection(jobList)
+	i := promptJobSelection(jobList, nil)
 	viewJob(clientset, &jobList.Items[i])
 }
 
+func filterJobs(jobs []batchv1.Job, pendingOnly bool) []batchv1.Job {
+	if !pendingOnly {
+		return jobs
+	}
+	pendingJobs := []batchv1.Job{}
+	for _, j := range jobs {
+		if getApprovalState(&j) == ApprovalPending {
+			pendingJobs = append(pendingJobs, j)
+		}
+	}
+	return pendingJobs
+}
+
+// printJobObject prints the job itself, for scripts that need more than the details of view.
+func printJobObject(job *batchv1.Job, output string) {
+	job.APIVersion = "batch/v1"
+	job.Kind = "Job"
+	var data []byte
+	var err error
+	switch output {
+	case OutputJSON:
+		data, err = json.MarshalIndent(job, "", "  ")
+		data = append(data, '\n')
+	case OutputYAML:
+		data, err = yaml.Marshal(job)
+	default:
+		fmt.Printf("Invalid output format %s, it must be %s, %s or %s\n", output, OutputText, OutputJSON, OutputYAML)
+		os.Exit(1)
+	}
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Print(string(data))
+}
+
 func viewJob(clientset *kubernetes.Clientset, job *batchv1.Job) {
 	podList := getJobPods(clientset, job)
 	sort.Slice(podList.Items, func(i, j int) bool {
 		return podList.Items[i].CreationTimestamp.UnixNano() < podList.Items[j].CreationTimestamp.UnixNano()
 	})
-	printJobDetails(job, podList)
+	events := getJobEvents(clientset, job, podList)
+	printJobDetails(color.Output, job, podList, events)
 }
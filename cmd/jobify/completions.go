@@ -0,0 +1,110 @@
+package jobify
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	IndexPlaceholder             = "$JOBIFY_INDEX"
+	CompletionIndexEnvVar        = "JOB_COMPLETION_INDEX"
+	CompletionIndexAnnotationKey = "batch.kubernetes.io/job-completion-index"
+)
+
+// setupJobCompletions applies the parallelism and completion options to a job. Indexed jobs get the
+// completion index as an environment variable, which the command can reference through $JOBIFY_INDEX.
+func setupJobCompletions(job *batchv1.Job, primaryContainerIndex int, opts *JobOptions) {
+	if opts.Parallelism > 0 {
+		parallelism := opts.Parallelism
+		job.Spec.Parallelism = &parallelism
+	}
+	if opts.Completions > 0 {
+		completions := opts.Completions
+		job.Spec.Completions = &completions
+	}
+	if !opts.Indexed {
+		return
+	}
+
+	completionMode := batchv1.IndexedCompletion
+	job.Spec.CompletionMode = &completionMode
+	// Every index gets its own retries
+	backoffLimit := *job.Spec.BackoffLimit * opts.Completions
+	job.Spec.BackoffLimit = &backoffLimit
+
+	container := &job.Spec.Template.Spec.Containers[primaryContainerIndex]
+	for _, e := range container.Env {
+		if e.Name == CompletionIndexEnvVar {
+			return
+		}
+	}
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name: CompletionIndexEnvVar,
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{
+				FieldPath: fmt.Sprintf("metadata.annotations['%s']", CompletionIndexAnnotationKey),
+			},
+		},
+	})
+}
+
+func checkCompletionOptions(opts *JobOptions) []string {
+	problems := []string{}
+	if opts.Indexed && opts.Completions <= 0 {
+		problems = append(problems, "Indexed jobs need the number of completions, set it with --completions")
+	}
+	if !opts.Indexed && strings.Contains(opts.Command, IndexPlaceholder) {
+		problems = append(problems, fmt.Sprintf("The command uses %s, but the job isn't indexed, use --indexed", IndexPlaceholder))
+	}
+	if opts.Parallelism < 0 || opts.Completions < 0 {
+		problems = append(problems, "Parallelism and completions can't be negative")
+	}
+	return problems
+}
+
+func isJobIndexed(job *batchv1.Job) bool {
+	return job.Spec.CompletionMode != nil && *job.Spec.CompletionMode == batchv1.IndexedCompletion
+}
+
+// printIndexProgress prints the state of the latest pod of every index of an indexed job.
+func printIndexProgress(w io.Writer, job *batchv1.Job, podList *corev1.PodList) {
+	completions := int32(0)
+	if job.Spec.Completions != nil {
+		completions = *job.Spec.Completions
+	}
+	fprintAttribute(w, "Completed Indexes", fmt.Sprintf("%d/%d %s", job.Status.Succeeded, completions, job.Status.CompletedIndexes))
+
+	latestPods := map[int]*corev1.Pod{}
+	attempts := map[int]int{}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		index, err := strconv.Atoi(getCompletionIndex(pod))
+		if err != nil {
+			continue
+		}
+		attempts[index]++
+		if latest, ok := latestPods[index]; !ok || latest.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			latestPods[index] = pod
+		}
+	}
+	if len(latestPods) == 0 {
+		return
+	}
+
+	indexes := []int{}
+	for index := range latestPods {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	fprintAttribute(w, "Indexes", "")
+	for _, index := range indexes {
+		pod := latestPods[index]
+		fprintAttributeWithIndentation(w, fmt.Sprintf("Index %d", index), fmt.Sprintf("%s, Pod: %s, Attempts: %d", pod.Status.Phase, pod.Name, attempts[index]), 1)
+	}
+}
@@ -0,0 +1,174 @@
+package jobify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	MaxConcurrentJobsAnnotationKey = "jobify/max-concurrent-jobs"
+	leaseDurationSeconds           = 30
+	leaseWaitTimeout               = time.Minute
+)
+
+func isJobActive(job *batchv1.Job) bool {
+	completed, failed := checkJobCondition(job)
+	return !completed && !failed && getApprovalState(job) != ApprovalRejected
+}
+
+// checkConcurrency returns a problem for every active job of the deployment that runs the same
+// command, and when the deployment's limit of concurrent jobs is reached.
+func checkConcurrency(clientset *kubernetes.Clientset, deployment *appv1.Deployment, opts *JobOptions) ([]string, error) {
+	jobs, err := clientset.BatchV1().Jobs(deployment.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "jobify=true",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []string{}
+	activeJobs := 0
+	for _, j := range jobs.Items {
+		if j.Annotations[SourceDeploymentAnnotationKey] != deployment.Name || !isJobActive(&j) {
+			continue
+		}
+		activeJobs++
+		if j.Annotations[UserCommandAnnotationKey] == opts.Command {
+			problems = append(problems, fmt.Sprintf("Job %s/%s is already running the same command (created by %s)", j.Namespace, j.Name, j.Annotations[CreatedByAnnotationKey]))
+		}
+	}
+
+	if value, ok := deployment.Annotations[MaxConcurrentJobsAnnotationKey]; ok {
+		maxJobs, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q", MaxConcurrentJobsAnnotationKey, value)
+		}
+		if activeJobs >= maxJobs {
+			problems = append(problems, fmt.Sprintf("%d jobs of this deployment are already active, the maximum is %d", activeJobs, maxJobs))
+		}
+	}
+	return problems, nil
+}
+
+// ConflictError is returned when a job conflicts with active jobs and isn't forced.
+type ConflictError struct {
+	Problems []string
+}
+
+func (e *ConflictError) Error() string {
+	return "the job conflicts with active jobs, use --force to create it anyway"
+}
+
+// printNotice prints the progress messages of createJobExclusively in the terminal.
+func printNotice(message string) {
+	faint.Println(message)
+}
+
+// printConflicts prints the problems of a conflict error, which createJobExclusively leaves to its
+// callers.
+func printConflicts(err error) {
+	if conflict, ok := err.(*ConflictError); ok {
+		printProblems(conflict.Problems)
+	}
+}
+
+// createJobExclusively creates a job while holding the deployment's lease, so that the concurrency
+// checks of users creating jobs at the same time don't race each other. Progress messages are passed
+// to notify rather than printed, so that the dashboard can show them.
+func createJobExclusively(clientset *kubernetes.Clientset, deployment *appv1.Deployment, opts *JobOptions, notify func(string), create func() error) error {
+	holder := opts.CreatedBy + "-" + randomString(5)
+	release, err := acquireDeploymentLease(clientset, deployment, holder, notify)
+	if apierrors.IsForbidden(err) {
+		notify("Not allowed to use leases, creating the job without locking the deployment")
+	} else if err != nil {
+		return err
+	} else {
+		defer release()
+	}
+
+	problems, err := checkConcurrency(clientset, deployment, opts)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 && !opts.Force {
+		return &ConflictError{Problems: problems}
+	}
+	return create()
+}
+
+var errLeaseTimeout = errors.New("timed out waiting for another user to finish creating a job from this deployment")
+
+func acquireDeploymentLease(clientset *kubernetes.Clientset, deployment *appv1.Deployment, holder string, notify func(string)) (release func(), err error) {
+	leases := clientset.CoordinationV1().Leases(deployment.Namespace)
+	name := "jobify-" + deployment.Name
+	duration := int32(leaseDurationSeconds)
+	deadline := time.Now().Add(leaseWaitTimeout)
+	waiting := false
+
+	for {
+		now := metav1.NewMicroTime(time.Now())
+		lease, err := leases.Get(context.TODO(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			lease, err = leases.Create(context.TODO(), &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: deployment.Namespace,
+					Labels:    map[string]string{"jobify": "true"},
+				},
+				Spec: coordinationv1.LeaseSpec{
+					HolderIdentity:       &holder,
+					LeaseDurationSeconds: &duration,
+					AcquireTime:          &now,
+					RenewTime:            &now,
+				},
+			}, metav1.CreateOptions{})
+		} else if err == nil && isLeaseFree(lease) {
+			lease.Spec.HolderIdentity = &holder
+			lease.Spec.LeaseDurationSeconds = &duration
+			lease.Spec.AcquireTime = &now
+			lease.Spec.RenewTime = &now
+			lease, err = leases.Update(context.TODO(), lease, metav1.UpdateOptions{})
+		} else if err == nil {
+			err = apierrors.NewConflict(coordinationv1.Resource("leases"), name, errors.New("held by "+*lease.Spec.HolderIdentity))
+		}
+
+		if err == nil {
+			return func() {
+				lease.Spec.HolderIdentity = nil
+				_, _ = leases.Update(context.TODO(), lease, metav1.UpdateOptions{})
+			}, nil
+		}
+		if !apierrors.IsConflict(err) && !apierrors.IsAlreadyExists(err) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, errLeaseTimeout
+		}
+		if !waiting {
+			notify("Waiting for another user to finish creating a job from this deployment...")
+			waiting = true
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func isLeaseFree(lease *coordinationv1.Lease) bool {
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		return true
+	}
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return time.Now().After(expiry)
+}
@@ -0,0 +1,246 @@
+package jobify
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	appv1 "k8s.io/api/apps/v1"
+	"k8s.io/client-go/util/homedir"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+	OutputYAML = "yaml"
+)
+
+// Config holds the user's defaults, every field is optional.
+type Config struct {
+	Context   string `json:"context,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// Favorites are "namespace/name" of deployments, the name can also be the deployment's alias
+	Favorites []string `json:"favorites,omitempty"`
+	// Aliases replace the first word of a command, e.g. "mig" for "bundle exec rake db:migrate"
+	Aliases      map[string]string `json:"aliases,omitempty"`
+	Output       string            `json:"output,omitempty"`
+	Color        *bool             `json:"color,omitempty"`
+	Emoji        *bool             `json:"emoji,omitempty"`
+	LogTailLines int64             `json:"logTailLines,omitempty"`
+}
+
+var userConfig = &Config{}
+
+func getConfigPath() string {
+	return filepath.Join(homedir.HomeDir(), ".config", "jobify", "config.yaml")
+}
+
+func readConfig() (*Config, error) {
+	config := &Config{}
+	data, err := ioutil.ReadFile(getConfigPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", getConfigPath(), err.Error())
+	}
+	return config, nil
+}
+
+func writeConfig(config *Config) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(getConfigPath()), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(getConfigPath(), data, 0644)
+}
+
+// loadConfig reads the config file and applies the defaults that don't have to be looked up when
+// they're used.
+func loadConfig() {
+	config, err := readConfig()
+	if err != nil {
+		fmt.Printf("Error reading config: %s\n", err.Error())
+		os.Exit(1)
+	}
+	userConfig = config
+	if userConfig.Color != nil && !*userConfig.Color {
+		color.NoColor = true
+	}
+}
+
+// emoji returns the emoji unless they were turned off in the config.
+func emoji(e string) string {
+	if userConfig.Emoji != nil && !*userConfig.Emoji {
+		return ""
+	}
+	return e
+}
+
+func getLogTailLines(defaultLines int64) int64 {
+	if userConfig.LogTailLines > 0 {
+		return userConfig.LogTailLines
+	}
+	return defaultLines
+}
+
+func getOutputFormat(flag string) string {
+	if flag != "" {
+		return flag
+	}
+	if userConfig.Output != "" {
+		return userConfig.Output
+	}
+	return OutputText
+}
+
+// expandCommandAlias replaces the first word of a command when it's an alias, keeping the rest of
+// the arguments.
+func expandCommandAlias(command string) string {
+	fields := strings.SplitN(command, " ", 2)
+	expanded, ok := userConfig.Aliases[fields[0]]
+	if !ok {
+		return command
+	}
+	if len(fields) == 1 {
+		return expanded
+	}
+	return expanded + " " + fields[1]
+}
+
+func isFavoriteDeployment(deployment *appv1.Deployment) bool {
+	for _, f := range userConfig.Favorites {
+		if f == deployment.Namespace+"/"+deployment.Name || f == deployment.Namespace+"/"+getDeploymentName(deployment) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortFavoriteDeployments moves the favorite deployments to the top, keeping the order otherwise.
+func sortFavoriteDeployments(deployments []appv1.Deployment) {
+	sort.SliceStable(deployments, func(i, j int) bool {
+		return isFavoriteDeployment(&deployments[i]) && !isFavoriteDeployment(&deployments[j])
+	})
+}
+
+func configView() {
+	data, err := yaml.Marshal(userConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	faint.Printf("# %s\n", getConfigPath())
+	fmt.Print(string(data))
+}
+
+func configGet(key string) {
+	switch {
+	case key == "context":
+		fmt.Println(userConfig.Context)
+	case key == "namespace":
+		fmt.Println(userConfig.Namespace)
+	case key == "favorites":
+		fmt.Println(strings.Join(userConfig.Favorites, ","))
+	case key == "output":
+		fmt.Println(getOutputFormat(""))
+	case key == "color":
+		fmt.Println(userConfig.Color == nil || *userConfig.Color)
+	case key == "emoji":
+		fmt.Println(userConfig.Emoji == nil || *userConfig.Emoji)
+	case key == "logTailLines":
+		fmt.Println(userConfig.LogTailLines)
+	case strings.HasPrefix(key, "aliases."):
+		fmt.Println(userConfig.Aliases[strings.TrimPrefix(key, "aliases.")])
+	default:
+		fmt.Printf("Unknown config key %s\n", key)
+		os.Exit(1)
+	}
+}
+
+// configSet changes one key of the config file, an empty value resets it to the default.
+func configSet(key, value string) {
+	config := userConfig
+	parseBool := func() *bool {
+		if value == "" {
+			return nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			fmt.Printf("Invalid value for %s, it must be true or false\n", key)
+			os.Exit(1)
+		}
+		return &b
+	}
+
+	switch {
+	case key == "context":
+		config.Context = value
+	case key == "namespace":
+		config.Namespace = value
+	case key == "favorites":
+		config.Favorites = nil
+		for _, f := range strings.Split(value, ",") {
+			if f = strings.TrimSpace(f); f == "" {
+				continue
+			} else if !strings.Contains(f, "/") {
+				fmt.Printf("Invalid favorite %s, it must be in the format namespace/deployment-name\n", f)
+				os.Exit(1)
+			}
+			config.Favorites = append(config.Favorites, f)
+		}
+	case key == "output":
+		if value != "" && value != OutputText && value != OutputJSON && value != OutputYAML {
+			fmt.Printf("Invalid output format %s, it must be %s, %s or %s\n", value, OutputText, OutputJSON, OutputYAML)
+			os.Exit(1)
+		}
+		config.Output = value
+	case key == "color":
+		config.Color = parseBool()
+	case key == "emoji":
+		config.Emoji = parseBool()
+	case key == "logTailLines":
+		config.LogTailLines = 0
+		if value != "" {
+			lines, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || lines <= 0 {
+				fmt.Println("Invalid value for logTailLines, it must be a positive number")
+				os.Exit(1)
+			}
+			config.LogTailLines = lines
+		}
+	case strings.HasPrefix(key, "aliases.") && key != "aliases.":
+		alias := strings.TrimPrefix(key, "aliases.")
+		if strings.Contains(alias, " ") {
+			fmt.Println("Aliases can't contain spaces")
+			os.Exit(1)
+		}
+		if value == "" {
+			delete(config.Aliases, alias)
+		} else {
+			if config.Aliases == nil {
+				config.Aliases = map[string]string{}
+			}
+			config.Aliases[alias] = value
+		}
+	default:
+		fmt.Printf("Unknown config key %s\n", key)
+		os.Exit(1)
+	}
+
+	if err := writeConfig(config); err != nil {
+		fmt.Printf("Error writing config: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
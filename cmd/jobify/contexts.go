@@ -0,0 +1,247 @@
+package jobify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// contextResult holds what was loaded from one kubeconfig context. An error only affects its own
+// context, the others are still usable.
+type contextResult struct {
+	Context     string
+	Clientset   *kubernetes.Clientset
+	Jobs        []batchv1.Job
+	Deployments []appv1.Deployment
+	Err         error
+}
+
+// getKubeContexts returns the contexts to work with, either all of the kubeconfig's or the given
+// ones, which must exist.
+func getKubeContexts(names []string, all bool) []string {
+	rawConfig, err := getClientConfig().RawConfig()
+	if err != nil {
+		fmt.Printf("Error reading kubeconfig: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if all {
+		contexts := []string{}
+		for name := range rawConfig.Contexts {
+			contexts = append(contexts, name)
+		}
+		sort.Strings(contexts)
+		return contexts
+	}
+	for _, name := range names {
+		if _, ok := rawConfig.Contexts[name]; !ok {
+			fmt.Printf("Context %s doesn't exist in the kubeconfig\n", name)
+			os.Exit(1)
+		}
+	}
+	return names
+}
+
+// loadFromContexts runs load concurrently for every context.
+func loadFromContexts(contexts []string, load func(r *contextResult) error) []*contextResult {
+	results := make([]*contextResult, len(contexts))
+	var wg sync.WaitGroup
+	for i, name := range contexts {
+		results[i] = &contextResult{Context: name}
+		wg.Add(1)
+		go func(r *contextResult) {
+			defer wg.Done()
+			r.Clientset, r.Err = getClientForContext(r.Context)
+			if r.Err == nil {
+				r.Err = load(r)
+			}
+		}(results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+func listContextJobs(r *contextResult) error {
+	jobs, err := r.Clientset.BatchV1().Jobs(userConfig.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "jobify=true",
+	})
+	if err == nil {
+		r.Jobs = jobs.Items
+	}
+	return err
+}
+
+func listContextDeployments(r *contextResult) error {
+	deployments, err := r.Clientset.AppsV1().Deployments(userConfig.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "jobify=true",
+	})
+	if err == nil {
+		r.Deployments = deployments.Items
+	}
+	return err
+}
+
+func listInContexts(contexts []string, pendingOnly bool) {
+	fmt.Printf("Loading jobs from %d contexts...\n", len(contexts))
+	results := loadFromContexts(contexts, listContextJobs)
+
+	type contextJob struct {
+		job    batchv1.Job
+		result *contextResult
+	}
+	contextJobs := []contextJob{}
+	for _, r := range results {
+		if r.Err != nil {
+			red.Printf("Couldn't list jobs in context %s: %s\n", r.Context, r.Err.Error())
+			continue
+		}
+		for _, j := range filterJobs(r.Jobs, pendingOnly) {
+			contextJobs = append(contextJobs, contextJob{j, r})
+		}
+	}
+	if len(contextJobs) == 0 {
+		fmt.Println("No jobs found")
+		return
+	}
+	sort.SliceStable(contextJobs, func(i, j int) bool {
+		return contextJobs[i].job.CreationTimestamp.UnixNano() > contextJobs[j].job.CreationTimestamp.UnixNano()
+	})
+
+	jobList := &batchv1.JobList{}
+	jobContexts := []string{}
+	for _, cj := range contextJobs {
+		jobList.Items = append(jobList.Items, cj.job)
+		jobContexts = append(jobContexts, cj.result.Context)
+	}
+	i := promptJobSelection(jobList, jobContexts)
+	fmt.Println()
+	printAttribute("Context", jobContexts[i])
+	// The job's links refer to its own context
+	kubeContext = jobContexts[i]
+	viewJob(contextJobs[i].result.Clientset, &jobList.Items[i])
+}
+
+// createInContexts creates the same job from the deployment with the same namespace and name in
+// every context, after a single confirmation.
+func createInContexts(contexts []string, opts *JobOptions) {
+	fmt.Printf("Loading deployments from %d contexts...\n", len(contexts))
+	results := loadFromContexts(contexts, listContextDeployments)
+	unreachable := []string{}
+	for _, r := range results {
+		if r.Err != nil {
+			unreachable = append(unreachable, fmt.Sprintf("%s: %s", r.Context, r.Err.Error()))
+		}
+	}
+	if len(unreachable) > 0 {
+		printProblems(unreachable)
+		os.Exit(1)
+	}
+
+	// Every deployment that exists in at least one context can be selected, the contexts it's
+	// missing from are reported after the selection
+	deploymentList := &appv1.DeploymentList{}
+	seen := map[string]bool{}
+	for _, r := range results {
+		for _, d := range r.Deployments {
+			if key := d.Namespace + "/" + d.Name; !seen[key] {
+				seen[key] = true
+				deploymentList.Items = append(deploymentList.Items, d)
+			}
+		}
+	}
+	if len(deploymentList.Items) == 0 {
+		fmt.Println("No deployments found")
+		return
+	}
+	sortFavoriteDeployments(deploymentList.Items)
+	selected := &deploymentList.Items[promptDeploymentSelection(deploymentList)]
+
+	deployments := map[string]*appv1.Deployment{}
+	checks := map[string]func(opts *JobOptions) (problems, warnings []string){}
+	problems := []string{}
+	for _, r := range results {
+		for i := range r.Deployments {
+			d := &r.Deployments[i]
+			if d.Namespace == selected.Namespace && d.Name == selected.Name {
+				deployments[r.Context] = d
+			}
+		}
+		if deployments[r.Context] == nil {
+			problems = append(problems, fmt.Sprintf("[%s] Deployment %s/%s doesn't exist", r.Context, selected.Namespace, selected.Name))
+		} else if err := validateDeployment(deployments[r.Context]); err != nil {
+			problems = append(problems, fmt.Sprintf("[%s] Invalid deployment: %s", r.Context, err.Error()))
+		} else {
+			checks[r.Context] = getJobChecks(r.Clientset, deployments[r.Context])
+		}
+	}
+	if len(problems) > 0 {
+		printProblems(problems)
+		os.Exit(1)
+	}
+
+	fmt.Println()
+	opts.Command = expandCommandAlias(promptCommand(selected.Annotations[DefaultCommandAnnotationKey]))
+	opts.CreatedBy = getCurrentUser(results[0].Clientset)
+	opts.Contexts = contexts
+
+	check := func(opts *JobOptions) (problems, warnings []string) {
+		if len(opts.ForEachItems) > 0 {
+			problems = append(problems, "--for-each can't be combined with --contexts")
+		}
+		for _, r := range results {
+			p, w := checks[r.Context](opts)
+			for _, s := range p {
+				problems = append(problems, fmt.Sprintf("[%s] %s", r.Context, s))
+			}
+			for _, s := range w {
+				warnings = append(warnings, fmt.Sprintf("[%s] %s", r.Context, s))
+			}
+		}
+		return problems, warnings
+	}
+	if !promptConfirmation(selected, opts, check) {
+		fmt.Println("Cancelled job creation, terminating...")
+		return
+	}
+
+	fmt.Println("Creating jobs...")
+	created := map[string]*batchv1.Job{}
+	for _, r := range results {
+		deployment := deployments[r.Context]
+		job := setupJob(deployment, opts)
+		err := createJobExclusively(r.Clientset, deployment, opts, printNotice, func() error {
+			_, err := createJobResource(r.Clientset, job, opts)
+			if err == nil {
+				recordJobHistory(job, r.Context)
+			}
+			return err
+		})
+		if err != nil {
+			printConflicts(err)
+			red.Printf("Error creating the job in context %s: %s\n", r.Context, err.Error())
+			continue
+		}
+		created[r.Context] = job
+		fmt.Printf("Created job %s/%s in context %s\n", job.Namespace, job.Name, r.Context)
+	}
+
+	fmt.Println()
+	fmt.Printf("Created %d of %d jobs\n", len(created), len(results))
+	if len(created) > 0 {
+		faint.Println("Use the following commands to view the jobs' details:")
+		for _, r := range results {
+			if job, ok := created[r.Context]; ok {
+				cyan.Printf("jobify view --context %s %s %s\n", r.Context, job.Namespace, job.Name)
+			}
+		}
+	}
+	if len(created) < len(results) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,441 @@
+package jobify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/fatih/color"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	dashboardDetailsInterval = 3 * time.Second
+	dashboardLogTailLines    = 50
+	keyEscape                = 27
+	keyBackspace             = "\x7f"
+	keyEnter                 = "\r"
+	keyCtrlC                 = "\x03"
+)
+
+type dashboard struct {
+	clientset *kubernetes.Clientset
+	lister    batchlisters.JobLister
+
+	jobs        []*batchv1.Job
+	selected    int
+	selectedKey string
+	offset      int
+
+	filter    string
+	filtering bool
+
+	detailsKey   string
+	detailsFetch time.Time
+	podList      *corev1.PodList
+	events       []corev1.Event
+	logs         string
+	showLogs     bool
+
+	message     string
+	confirmText string
+	confirm     func()
+	quit        bool
+}
+
+func runDashboard(clientset *kubernetes.Clientset) {
+	stdin := int(os.Stdin.Fd())
+	if !readline.IsTerminal(stdin) {
+		fmt.Println("The dashboard can only be used in an interactive terminal")
+		os.Exit(1)
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = "jobify=true"
+		}),
+	)
+	changes := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+	jobInformer := factory.Batch().V1().Jobs()
+	jobInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { notify() },
+		UpdateFunc: func(oldObj, newObj interface{}) { notify() },
+		DeleteFunc: func(obj interface{}) { notify() },
+	})
+
+	fmt.Println("Loading jobs...")
+	stop := make(chan struct{})
+	defer close(stop)
+	factory.Start(stop)
+	factory.WaitForCacheSync(stop)
+
+	state, err := readline.MakeRaw(stdin)
+	if err != nil {
+		fmt.Printf("Error setting up the terminal: %s\n", err.Error())
+		os.Exit(1)
+	}
+	defer readline.Restore(stdin, state)
+	fmt.Fprint(color.Output, "\033[?1049h\033[?25l")
+	defer fmt.Fprint(color.Output, "\033[?25h\033[?1049l")
+
+	keys := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go readKeys(keys, done)
+
+	d := &dashboard{
+		clientset: clientset,
+		lister:    jobInformer.Lister(),
+		podList:   &corev1.PodList{},
+		showLogs:  true,
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for !d.quit {
+		d.refreshJobs()
+		d.refreshDetails()
+		d.render()
+
+		select {
+		case <-changes:
+		case <-ticker.C:
+		case key, ok := <-keys:
+			if !ok {
+				return
+			}
+			d.handleKey(key)
+		}
+	}
+}
+
+func (d *dashboard) refreshJobs() {
+	all, _ := d.lister.List(labels.Everything())
+	filter := strings.ToLower(strings.Replace(d.filter, " ", "", -1))
+	d.jobs = []*batchv1.Job{}
+	for _, j := range all {
+		searchable := strings.ToLower(strings.Replace(j.Namespace+j.Name+j.Annotations[UserCommandAnnotationKey]+j.Annotations[SourceAliasAnnotationKey], " ", "", -1))
+		if strings.Contains(searchable, filter) {
+			d.jobs = append(d.jobs, j)
+		}
+	}
+	sort.Slice(d.jobs, func(i, j int) bool {
+		return d.jobs[i].CreationTimestamp.UnixNano() > d.jobs[j].CreationTimestamp.UnixNano()
+	})
+
+	// Keep the same job selected when jobs are added or removed above it
+	for i, j := range d.jobs {
+		if j.Namespace+"/"+j.Name == d.selectedKey {
+			d.selected = i
+		}
+	}
+	if d.selected >= len(d.jobs) {
+		d.selected = len(d.jobs) - 1
+	}
+	if d.selected < 0 {
+		d.selected = 0
+	}
+	if job := d.selectedJob(); job != nil {
+		d.selectedKey = job.Namespace + "/" + job.Name
+	}
+}
+
+func (d *dashboard) selectedJob() *batchv1.Job {
+	if len(d.jobs) == 0 {
+		return nil
+	}
+	return d.jobs[d.selected]
+}
+
+func (d *dashboard) refreshDetails() {
+	job := d.selectedJob()
+	if job == nil {
+		return
+	}
+	if d.detailsKey == d.selectedKey && time.Since(d.detailsFetch) < dashboardDetailsInterval {
+		return
+	}
+	d.detailsKey = d.selectedKey
+	d.detailsFetch = time.Now()
+
+	podList, err := listJobPods(d.clientset, job)
+	if err != nil {
+		d.message = fmt.Sprintf("Error getting job pods: %s", err.Error())
+		return
+	}
+	sort.Slice(podList.Items, func(i, j int) bool {
+		return podList.Items[i].CreationTimestamp.UnixNano() < podList.Items[j].CreationTimestamp.UnixNano()
+	})
+	d.podList = podList
+	if events, err := listJobEvents(d.clientset, job, podList); err == nil {
+		d.events = events
+	}
+
+	d.logs = ""
+	if d.showLogs && len(podList.Items) > 0 {
+		pod := &podList.Items[len(podList.Items)-1]
+		logs, err := getPodLogs(d.clientset, pod, job.Annotations[PrimaryContainerAnnotationKey], getLogTailLines(dashboardLogTailLines))
+		if err != nil {
+			logs = fmt.Sprintf("Logs unavailable: %s\n", err.Error())
+		}
+		d.logs = logs
+	}
+}
+
+// handleKey handles a key read by readKeys, which passes arrow keys as a whole escape sequence and
+// anything else, including a lone ESC, one byte at a time.
+func (d *dashboard) handleKey(key string) {
+	if d.confirm != nil {
+		if key == "y" {
+			d.confirm()
+		} else {
+			d.message = "Cancelled"
+		}
+		d.confirm = nil
+		d.confirmText = ""
+		return
+	}
+
+	if d.filtering {
+		switch {
+		case key == keyEnter:
+			d.filtering = false
+		case key == keyBackspace:
+			if len(d.filter) > 0 {
+				d.filter = d.filter[:len(d.filter)-1]
+			}
+		case key == keyCtrlC:
+			d.quit = true
+		case len(key) == 1 && key[0] >= ' ' && key < keyBackspace:
+			d.filter += key
+		}
+		return
+	}
+
+	job := d.selectedJob()
+	switch key {
+	case "q", keyCtrlC:
+		d.quit = true
+	case "j", keyDown:
+		d.move(1)
+	case "k", keyUp:
+		d.move(-1)
+	case "/":
+		d.filtering = true
+	case "l":
+		d.showLogs = !d.showLogs
+		d.detailsFetch = time.Time{}
+	case "r":
+		if job != nil {
+			d.ask(fmt.Sprintf("Rerun %s/%s? (y/n)", job.Namespace, job.Name), func() {
+				newJob, err := rerunJob(d.clientset, job, d.notify)
+				if conflict, ok := err.(*ConflictError); ok {
+					d.message = fmt.Sprintf("Error rerunning job: %s: %s", err.Error(), strings.Join(conflict.Problems, "; "))
+					return
+				} else if err != nil {
+					d.message = fmt.Sprintf("Error rerunning job: %s", err.Error())
+					return
+				}
+				d.message = fmt.Sprintf("Created job %s/%s", newJob.Namespace, newJob.Name)
+				d.selectedKey = newJob.Namespace + "/" + newJob.Name
+			})
+		}
+	case "c":
+		if job != nil {
+			d.ask(fmt.Sprintf("Cancel %s/%s? (y/n)", job.Namespace, job.Name), func() {
+				if err := cancelJob(d.clientset, job); err != nil {
+					d.message = fmt.Sprintf("Error cancelling job: %s", err.Error())
+					return
+				}
+				d.message = fmt.Sprintf("Cancelled job %s/%s", job.Namespace, job.Name)
+				d.detailsFetch = time.Time{}
+			})
+		}
+	case "d":
+		if job != nil {
+			d.ask(fmt.Sprintf("Delete %s/%s? (y/n)", job.Namespace, job.Name), func() {
+				if err := deleteJob(d.clientset, job); err != nil {
+					d.message = fmt.Sprintf("Error deleting job: %s", err.Error())
+					return
+				}
+				d.message = fmt.Sprintf("Deleted job %s/%s", job.Namespace, job.Name)
+			})
+		}
+	}
+}
+
+// notify shows a progress message in the status line right away, since the terminal can't be
+// printed to while the dashboard is shown.
+func (d *dashboard) notify(message string) {
+	d.message = message
+	d.render()
+}
+
+func (d *dashboard) ask(text string, confirm func()) {
+	d.confirmText = text
+	d.confirm = confirm
+}
+
+func (d *dashboard) move(delta int) {
+	d.selected += delta
+	if d.selected >= len(d.jobs) {
+		d.selected = len(d.jobs) - 1
+	}
+	if d.selected < 0 {
+		d.selected = 0
+	}
+	if job := d.selectedJob(); job != nil {
+		d.selectedKey = job.Namespace + "/" + job.Name
+	}
+}
+
+func (d *dashboard) render() {
+	width, height, err := readline.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	listHeight := (height - 4) / 3
+	if listHeight < 3 {
+		listHeight = 3
+	}
+	paneHeight := height - 4 - listHeight
+	detailsHeight := paneHeight
+	logsHeight := 0
+	if d.showLogs {
+		detailsHeight = paneHeight / 2
+		logsHeight = paneHeight - detailsHeight
+	}
+
+	lines := []string{}
+	if d.filtering {
+		lines = append(lines, cyan.Sprint("Filter: ")+d.filter+"▏")
+	} else if d.filter != "" {
+		lines = append(lines, cyan.Sprint("Filter: ")+d.filter)
+	} else {
+		lines = append(lines, cyan.Sprint("jobify dashboard")+faint.Sprintf(" (%d jobs)", len(d.jobs)))
+	}
+
+	if d.selected < d.offset {
+		d.offset = d.selected
+	} else if d.selected >= d.offset+listHeight {
+		d.offset = d.selected - listHeight + 1
+	}
+	for i := d.offset; i < d.offset+listHeight; i++ {
+		if i >= len(d.jobs) {
+			lines = append(lines, "")
+			continue
+		}
+		lines = append(lines, d.formatJobRow(d.jobs[i], i == d.selected))
+	}
+
+	lines = append(lines, faint.Sprint(strings.Repeat("─", width)))
+	if job := d.selectedJob(); job != nil {
+		buf := new(bytes.Buffer)
+		printJobDetails(buf, job, d.podList, d.events)
+		lines = append(lines, fitLines(strings.Split(strings.TrimLeft(buf.String(), "\n"), "\n"), detailsHeight, false)...)
+		if d.showLogs {
+			lines = append(lines, faint.Sprint(strings.Repeat("─", width)))
+			lines = append(lines, fitLines(strings.Split(strings.TrimRight(d.logs, "\n"), "\n"), logsHeight-1, true)...)
+		}
+	} else {
+		lines = append(lines, fitLines([]string{"No jobs found"}, paneHeight, false)...)
+	}
+
+	status := faint.Sprint("↑/↓: select, /: filter, l: toggle logs, r: rerun, c: cancel, d: delete, q: quit")
+	if d.confirmText != "" {
+		status = yellow.Sprint(d.confirmText)
+	} else if d.message != "" {
+		status = yellow.Sprint(d.message) + faint.Sprint("  (q: quit)")
+	}
+	lines = append(lines, status)
+
+	out := new(bytes.Buffer)
+	out.WriteString("\033[H")
+	for i, line := range lines {
+		if i >= height {
+			break
+		}
+		out.WriteString(truncateLine(line, width))
+		out.WriteString("\033[K")
+		if i < height-1 && i < len(lines)-1 {
+			out.WriteString("\r\n")
+		}
+	}
+	out.WriteString("\033[J")
+	fmt.Fprint(color.Output, out.String())
+}
+
+func (d *dashboard) formatJobRow(job *batchv1.Job, selected bool) string {
+	_, icon := getJobState(job)
+	cursor := "  "
+	if selected {
+		cursor = "> "
+	}
+	age := duration.HumanDuration(time.Since(job.CreationTimestamp.Time))
+	return fmt.Sprintf("%s%s %s %s %s",
+		cursor,
+		icon,
+		cyan.Sprintf("%s/%s", job.Namespace, job.Name),
+		job.Annotations[UserCommandAnnotationKey],
+		faint.Sprintf("age: %s, duration: %s", age, getJobElapsedTime(job)),
+	)
+}
+
+// fitLines pads or cuts lines to exactly the given height, keeping the last lines when tail is set.
+func fitLines(lines []string, height int, tail bool) []string {
+	if height <= 0 {
+		return []string{}
+	}
+	if len(lines) > height {
+		if tail {
+			lines = lines[len(lines)-height:]
+		} else {
+			lines = lines[:height]
+		}
+	}
+	for len(lines) < height {
+		lines = append(lines, "")
+	}
+	return lines
+}
+
+// truncateLine cuts a line to the terminal width without counting or breaking color escape sequences.
+func truncateLine(line string, width int) string {
+	visible := 0
+	inEscape := false
+	for i, r := range line {
+		if inEscape {
+			if r == 'm' {
+				inEscape = false
+			}
+			continue
+		}
+		if r == keyEscape {
+			inEscape = true
+			continue
+		}
+		visible++
+		if visible > width {
+			return line[:i] + "\033[0m"
+		}
+	}
+	return line
+}
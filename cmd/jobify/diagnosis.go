@@ -0,0 +1,93 @@
+package jobify
+
+import (
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var reasonHints = map[string]string{
+	"DeadlineExceeded":           "The job ran longer than its active deadline and was terminated",
+	"BackoffLimitExceeded":       "Every attempt of the job failed, check the logs of the failed pods for the cause",
+	"ErrImagePull":               "The image couldn't be pulled, check that the image tag exists and that the cluster can access the registry",
+	"ImagePullBackOff":           "The image couldn't be pulled, check that the image tag exists and that the cluster can access the registry",
+	"InvalidImageName":           "The image name is invalid, check the image tag override",
+	"OOMKilled":                  "A container ran out of memory, increase its memory limit or reduce the job's memory usage",
+	"CreateContainerConfigError": "A container couldn't be configured, check that the ConfigMaps and Secrets it references exist",
+	"FailedMount":                "A volume couldn't be mounted, check that the volumes the deployment references exist",
+	"Evicted":                    "The pod was evicted from its node, usually because the node ran low on resources",
+}
+
+// diagnoseJob translates the failure reasons found in a job's conditions, its pods' states and
+// its events into hints on how to fix the problem.
+func diagnoseJob(job *batchv1.Job, podList *corev1.PodList, events []corev1.Event) []string {
+	hints := []string{}
+	addHint := func(hint string) {
+		for _, h := range hints {
+			if h == hint {
+				return
+			}
+		}
+		hints = append(hints, hint)
+	}
+
+	for _, c := range job.Status.Conditions {
+		if c.Status == corev1.ConditionTrue && job.Annotations[CancelledAnnotationKey] != "true" {
+			if hint, ok := reasonHints[c.Reason]; ok {
+				addHint(hint)
+			}
+		}
+	}
+
+	for _, p := range podList.Items {
+		if hint, ok := reasonHints[p.Status.Reason]; ok {
+			addHint(hint)
+		}
+		for _, c := range p.Status.ContainerStatuses {
+			if c.State.Waiting != nil {
+				if hint, ok := reasonHints[c.State.Waiting.Reason]; ok {
+					addHint(hint)
+				}
+			}
+			if c.State.Terminated != nil {
+				if hint, ok := reasonHints[c.State.Terminated.Reason]; ok {
+					addHint(hint)
+				}
+			}
+		}
+	}
+
+	for _, e := range events {
+		if e.Type != corev1.EventTypeWarning {
+			continue
+		}
+		message := strings.ToLower(e.Message)
+		switch e.Reason {
+		case "FailedCreate":
+			if strings.Contains(message, "exceeded quota") {
+				addHint("The namespace's resource quota is exhausted, wait for other workloads to finish or ask for a bigger quota")
+			} else {
+				addHint("The job controller couldn't create pods: " + e.Message)
+			}
+		case "FailedScheduling":
+			if strings.Contains(message, "insufficient") {
+				addHint("No node has enough free resources for the pod, the cluster may need to scale up or the resource requests are too high")
+			} else if strings.Contains(message, "node selector") || strings.Contains(message, "affinity") || strings.Contains(message, "taint") {
+				addHint("No node matches the pod's node selector, affinity or tolerations")
+			} else {
+				addHint("The pod couldn't be scheduled: " + e.Message)
+			}
+		case "Failed", "BackOff":
+			if strings.Contains(message, "pull") {
+				addHint(reasonHints["ErrImagePull"])
+			}
+		default:
+			if hint, ok := reasonHints[e.Reason]; ok {
+				addHint(hint)
+			}
+		}
+	}
+
+	return hints
+}
@@ -0,0 +1,276 @@
+package jobify
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// jobDurationBuckets are the upper bounds of the job duration histogram, in seconds.
+var jobDurationBuckets = []float64{10, 30, 60, 300, 600, 1800, 3600, 7200, 21600, 86400}
+
+// metricLabels is a rendered label set, e.g. {namespace="a",deployment="b"}, used as a map key.
+type metricLabels string
+
+func newMetricLabels(names []string, values ...string) metricLabels {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(values[i])))
+	}
+	return metricLabels("{" + strings.Join(pairs, ",") + "}")
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range jobDurationBuckets {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// Exporter keeps Prometheus metrics of jobify jobs up to date from an informer. Counters start from
+// the jobs that exist when it starts, and keep counting jobs after they're deleted. It works with
+// any clientset, including the fake one.
+type Exporter struct {
+	clientset kubernetes.Interface
+	namespace string
+	lister    batchlisters.JobLister
+	synced    cache.InformerSynced
+
+	mu        sync.Mutex
+	seen      map[types.UID]bool
+	finished  map[types.UID]bool
+	created   map[metricLabels]float64
+	completed map[metricLabels]float64
+	durations map[metricLabels]*histogram
+}
+
+var (
+	createdLabelNames  = []string{"namespace", "deployment", "created_by"}
+	finishedLabelNames = []string{"namespace", "deployment", "state"}
+	activeLabelNames   = []string{"namespace", "deployment", "state"}
+)
+
+func NewExporter(clientset kubernetes.Interface, namespace string) *Exporter {
+	return &Exporter{
+		clientset: clientset,
+		namespace: namespace,
+		seen:      map[types.UID]bool{},
+		finished:  map[types.UID]bool{},
+		created:   map[metricLabels]float64{},
+		completed: map[metricLabels]float64{},
+		durations: map[metricLabels]*histogram{},
+	}
+}
+
+// Start runs the informer until stop is closed, and waits for its cache to sync.
+func (e *Exporter) Start(stop <-chan struct{}) {
+	factory := informers.NewSharedInformerFactoryWithOptions(e.clientset, 0,
+		informers.WithNamespace(e.namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = "jobify=true"
+		}),
+	)
+	jobInformer := factory.Batch().V1().Jobs()
+	jobInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { e.observe(obj) },
+		UpdateFunc: func(oldObj, newObj interface{}) { e.observe(newObj) },
+		DeleteFunc: func(obj interface{}) { e.forget(obj) },
+	})
+	e.lister = jobInformer.Lister()
+	e.synced = jobInformer.Informer().HasSynced
+	factory.Start(stop)
+	factory.WaitForCacheSync(stop)
+}
+
+// getExporterJobState returns the state of a job as a label value, e.g. pending_approval.
+func getExporterJobState(job *batchv1.Job) string {
+	state, _ := getJobState(job)
+	return strings.Replace(strings.ToLower(state), " ", "_", -1)
+}
+
+func (e *Exporter) observe(obj interface{}) {
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	deployment := job.Annotations[SourceDeploymentAnnotationKey]
+	if !e.seen[job.UID] {
+		e.seen[job.UID] = true
+		e.created[newMetricLabels(createdLabelNames, job.Namespace, deployment, job.Annotations[CreatedByAnnotationKey])]++
+	}
+
+	completed, failed := checkJobCondition(job)
+	rejected := getApprovalState(job) == ApprovalRejected
+	if e.finished[job.UID] || !(completed || failed || rejected) {
+		return
+	}
+	e.finished[job.UID] = true
+	state := getExporterJobState(job)
+	e.completed[newMetricLabels(finishedLabelNames, job.Namespace, deployment, state)]++
+	if job.Status.StartTime != nil {
+		key := newMetricLabels(finishedLabelNames, job.Namespace, deployment, state)
+		h, ok := e.durations[key]
+		if !ok {
+			h = &histogram{buckets: make([]uint64, len(jobDurationBuckets))}
+			e.durations[key] = h
+		}
+		h.observe(getJobCompletionTime(job).Sub(job.Status.StartTime.Time).Seconds())
+	}
+}
+
+func (e *Exporter) forget(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.seen, job.UID)
+	delete(e.finished, job.UID)
+}
+
+// WriteMetrics writes the metrics in the Prometheus text format.
+func (e *Exporter) WriteMetrics(w io.Writer) error {
+	jobs, err := e.lister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	current := map[metricLabels]float64{}
+	for _, job := range jobs {
+		state := getExporterJobState(job)
+		if state == "active" || state == "pending_approval" {
+			current[newMetricLabels(activeLabelNames, job.Namespace, job.Annotations[SourceDeploymentAnnotationKey], state)]++
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	writeMetricFamily(w, "jobify_jobs_created_total", "counter", "Jobs created, by deployment and user.", e.created)
+	writeMetricFamily(w, "jobify_jobs_finished_total", "counter", "Jobs that completed, failed, were cancelled or were rejected.", e.completed)
+	writeMetricFamily(w, "jobify_jobs_current", "gauge", "Jobs that are active or pending approval.", current)
+	writeHistogram(w, "jobify_job_duration_seconds", "Time from the start of finished jobs until they completed or failed.", e.durations)
+	return nil
+}
+
+func sortedLabels(values map[metricLabels]float64) []metricLabels {
+	keys := []metricLabels{}
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func writeMetricFamily(w io.Writer, name, metricType, help string, values map[metricLabels]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	for _, k := range sortedLabels(values) {
+		fmt.Fprintf(w, "%s%s %s\n", name, k, formatMetricValue(values[k]))
+	}
+}
+
+func writeHistogram(w io.Writer, name, help string, histograms map[metricLabels]*histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	keys := []metricLabels{}
+	for k := range histograms {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, k := range keys {
+		h := histograms[k]
+		// The le label is added to the job's labels
+		prefix := strings.TrimSuffix(string(k), "}") + ","
+		for i, bound := range jobDurationBuckets {
+			fmt.Fprintf(w, "%s_bucket%sle=\"%s\"} %d\n", name, prefix, formatMetricValue(bound), h.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%sle=\"+Inf\"} %d\n", name, prefix, h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, k, formatMetricValue(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, k, h.count)
+	}
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := e.WriteMetrics(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getServiceClient uses the pod's service account when running in-cluster, and the kubeconfig
+// otherwise.
+func getServiceClient(inCluster bool) *kubernetes.Clientset {
+	if !inCluster {
+		return getClient()
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		fmt.Printf("Error creating in-cluster config: %s\n", err.Error())
+		os.Exit(1)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		fmt.Printf("Error creating Kubernetes client: %s\n", err.Error())
+		os.Exit(1)
+	}
+	return clientset
+}
+
+func runExporter(clientset kubernetes.Interface, namespace, address string) {
+	exporter := NewExporter(clientset, namespace)
+	stop := make(chan struct{})
+	defer close(stop)
+	fmt.Println("Loading jobs...")
+	exporter.Start(stop)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporter)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !exporter.synced() {
+			http.Error(w, "not synced", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	fmt.Printf("Serving metrics on %s/metrics\n", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		fmt.Printf("Error serving metrics: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,132 @@
+package jobify
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newExporterTestJob(name, createdBy, approval string) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			UID:       types.UID("uid-" + name),
+			Labels:    map[string]string{"jobify": "true"},
+			Annotations: map[string]string{
+				SourceDeploymentAnnotationKey: "web",
+				CreatedByAnnotationKey:        createdBy,
+			},
+		},
+	}
+	if approval != "" {
+		job.Annotations[ApprovalAnnotationKey] = approval
+	}
+	return job
+}
+
+// waitForMetrics waits until the exporter's metrics contain, or don't contain, every line, since
+// the informer handles changes asynchronously.
+func waitForMetrics(t *testing.T, e *Exporter, present, absent []string) {
+	t.Helper()
+	var metrics string
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		buf := new(bytes.Buffer)
+		if err := e.WriteMetrics(buf); err != nil {
+			t.Fatal(err)
+		}
+		metrics = buf.String()
+		ok := true
+		for _, line := range present {
+			ok = ok && strings.Contains(metrics, line+"\n")
+		}
+		for _, line := range absent {
+			ok = ok && !strings.Contains(metrics, line)
+		}
+		if ok {
+			return
+		}
+	}
+	t.Fatalf("metrics should contain %q and not %q, got:\n%s", present, absent, metrics)
+}
+
+func TestExporter(t *testing.T) {
+	jobs := []*batchv1.Job{
+		newExporterTestJob("web-1", "alice", ""),
+		newExporterTestJob("web-2", "alice", ApprovalPending),
+	}
+	clientset := fake.NewSimpleClientset(jobs[0], jobs[1])
+	exporter := NewExporter(clientset, "default")
+	stop := make(chan struct{})
+	defer close(stop)
+	exporter.Start(stop)
+
+	const labels = `namespace="default",deployment="web"`
+	waitForMetrics(t, exporter, []string{
+		`jobify_jobs_created_total{` + labels + `,created_by="alice"} 2`,
+		`jobify_jobs_current{` + labels + `,state="active"} 1`,
+		`jobify_jobs_current{` + labels + `,state="pending_approval"} 1`,
+	}, []string{"jobify_jobs_finished_total{", "jobify_job_duration_seconds_bucket{"})
+
+	// Completing a job counts it as finished once, however many updates follow
+	started := metav1.NewTime(time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC))
+	completed := metav1.NewTime(started.Add(45 * time.Second))
+	jobs[0].Status.StartTime = &started
+	jobs[0].Status.CompletionTime = &completed
+	jobs[0].Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	for i := 0; i < 2; i++ {
+		if _, err := clientset.BatchV1().Jobs("default").Update(context.TODO(), jobs[0], metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const finishedLabels = labels + `,state="completed"`
+	waitForMetrics(t, exporter, []string{
+		`jobify_jobs_created_total{` + labels + `,created_by="alice"} 2`,
+		`jobify_jobs_finished_total{` + finishedLabels + `} 1`,
+		`jobify_jobs_current{` + labels + `,state="pending_approval"} 1`,
+		`jobify_job_duration_seconds_bucket{` + finishedLabels + `,le="10"} 0`,
+		`jobify_job_duration_seconds_bucket{` + finishedLabels + `,le="30"} 0`,
+		`jobify_job_duration_seconds_bucket{` + finishedLabels + `,le="60"} 1`,
+		`jobify_job_duration_seconds_bucket{` + finishedLabels + `,le="86400"} 1`,
+		`jobify_job_duration_seconds_bucket{` + finishedLabels + `,le="+Inf"} 1`,
+		`jobify_job_duration_seconds_sum{` + finishedLabels + `} 45`,
+		`jobify_job_duration_seconds_count{` + finishedLabels + `} 1`,
+	}, []string{`state="active"`})
+
+	// Rejecting the other job finishes it without a duration, since it never started
+	jobs[1].Annotations[ApprovalAnnotationKey] = ApprovalRejected
+	if _, err := clientset.BatchV1().Jobs("default").Update(context.TODO(), jobs[1], metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForMetrics(t, exporter, []string{
+		`jobify_jobs_finished_total{` + labels + `,state="rejected"} 1`,
+		`jobify_job_duration_seconds_count{` + finishedLabels + `} 1`,
+	}, []string{"jobify_jobs_current{", `jobify_job_duration_seconds_count{` + labels + `,state="rejected"}`})
+
+	// Counters keep the deleted jobs
+	for _, j := range jobs {
+		if err := clientset.BatchV1().Jobs("default").Delete(context.TODO(), j.Name, metav1.DeleteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created := newExporterTestJob("web-3", "bob", "")
+	if _, err := clientset.BatchV1().Jobs("default").Create(context.TODO(), created, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForMetrics(t, exporter, []string{
+		`jobify_jobs_created_total{` + labels + `,created_by="alice"} 2`,
+		`jobify_jobs_created_total{` + labels + `,created_by="bob"} 1`,
+		`jobify_jobs_finished_total{` + finishedLabels + `} 1`,
+		`jobify_jobs_finished_total{` + labels + `,state="rejected"} 1`,
+		`jobify_jobs_current{` + labels + `,state="active"} 1`,
+		`jobify_job_duration_seconds_count{` + finishedLabels + `} 1`,
+	}, []string{`state="pending_approval"`})
+}
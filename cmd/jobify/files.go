@@ -0,0 +1,230 @@
+package jobify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	FilesAnnotationKey       = "jobify/files"
+	SecretFilesAnnotationKey = "jobify/secret-files"
+	FilesDirPlaceholder      = "$JOBIFY_FILES_DIR"
+	DefaultFilesDir          = "/jobify/files"
+	// ConfigMaps and Secrets are limited to 1MiB, including their metadata
+	maxFilesSize = 900 * 1024
+)
+
+// JobFile is a local file uploaded into the job's primary container.
+type JobFile struct {
+	LocalPath  string `json:"localPath"`
+	RemotePath string `json:"remotePath"`
+	Data       []byte `json:"-"`
+}
+
+// readJobFile reads a file given as "local-path[:remote-path]". Without a remote path, the file is
+// mounted into $JOBIFY_FILES_DIR with its own name.
+func readJobFile(value string) (JobFile, error) {
+	file := JobFile{LocalPath: value}
+	if colonIndex := strings.LastIndex(value, ":"); colonIndex != -1 && path.IsAbs(value[colonIndex+1:]) {
+		file.LocalPath = value[:colonIndex]
+		file.RemotePath = value[colonIndex+1:]
+	} else {
+		file.RemotePath = path.Join(DefaultFilesDir, filepath.Base(file.LocalPath))
+	}
+	data, err := ioutil.ReadFile(file.LocalPath)
+	if err != nil {
+		return file, err
+	}
+	file.Data = data
+	return file, nil
+}
+
+func readJobFiles(values []string) ([]JobFile, error) {
+	files := []JobFile{}
+	for _, value := range values {
+		file, err := readJobFile(value)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func checkFileOptions(opts *JobOptions) []string {
+	problems := []string{}
+	size := 0
+	remotePaths := map[string]bool{}
+	for _, f := range opts.Files {
+		size += len(f.Data)
+		if remotePaths[f.RemotePath] {
+			problems = append(problems, fmt.Sprintf("More than one file is uploaded to %s", f.RemotePath))
+		}
+		remotePaths[f.RemotePath] = true
+	}
+	if size > maxFilesSize {
+		problems = append(problems, fmt.Sprintf("The uploaded files are %dKiB, the maximum is %dKiB", size/1024, maxFilesSize/1024))
+	}
+	return problems
+}
+
+func getJobFilesName(job *batchv1.Job) string {
+	return job.Name + "-files"
+}
+
+func getJobFileKey(i int) string {
+	return fmt.Sprintf("file-%d", i)
+}
+
+// setupJobFiles mounts every uploaded file into the primary container on its own, so that the rest
+// of the directory stays as it is in the image.
+func setupJobFiles(job *batchv1.Job, opts *JobOptions) {
+	if len(opts.Files) == 0 {
+		return
+	}
+	volume := corev1.Volume{Name: "jobify-files"}
+	items := []corev1.KeyToPath{}
+	for i := range opts.Files {
+		items = append(items, corev1.KeyToPath{Key: getJobFileKey(i), Path: getJobFileKey(i)})
+	}
+	if opts.SecretFiles {
+		volume.Secret = &corev1.SecretVolumeSource{SecretName: getJobFilesName(job), Items: items}
+		job.Annotations[SecretFilesAnnotationKey] = "true"
+	} else {
+		volume.ConfigMap = &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: getJobFilesName(job)},
+			Items:                items,
+		}
+	}
+	job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, volume)
+
+	container := getJobPrimaryContainer(job)
+	for i, f := range opts.Files {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      volume.Name,
+			MountPath: f.RemotePath,
+			SubPath:   getJobFileKey(i),
+			ReadOnly:  true,
+		})
+	}
+	data, _ := json.Marshal(opts.Files)
+	job.Annotations[FilesAnnotationKey] = string(data)
+}
+
+// createJobResource creates a job along with the ConfigMap or Secret of its files. The job owns it,
+// so that it's deleted with the job.
+func createJobResource(clientset *kubernetes.Clientset, job *batchv1.Job, opts *JobOptions) (*batchv1.Job, error) {
+	if err := setJobCreatorIdentity(clientset, job); err != nil {
+		return nil, err
+	}
+	if len(opts.Files) == 0 {
+		return clientset.BatchV1().Jobs(job.Namespace).Create(context.TODO(), job, metav1.CreateOptions{})
+	}
+
+	meta := metav1.ObjectMeta{
+		Name:      getJobFilesName(job),
+		Namespace: job.Namespace,
+		Labels:    map[string]string{"jobify": "true", "job-name": job.Name},
+	}
+	data := map[string][]byte{}
+	for i, f := range opts.Files {
+		data[getJobFileKey(i)] = f.Data
+	}
+	// The files are created first so that the pods don't wait for them, the owner is set once the
+	// job exists
+	var setOwner func(owner metav1.OwnerReference) error
+	var deleteFiles func() error
+	if opts.SecretFiles {
+		secret, err := clientset.CoreV1().Secrets(job.Namespace).Create(context.TODO(), &corev1.Secret{ObjectMeta: meta, Data: data}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create the secret of the files: %s", err.Error())
+		}
+		setOwner = func(owner metav1.OwnerReference) error {
+			secret.OwnerReferences = []metav1.OwnerReference{owner}
+			_, err := clientset.CoreV1().Secrets(job.Namespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+			return err
+		}
+		deleteFiles = func() error {
+			return clientset.CoreV1().Secrets(job.Namespace).Delete(context.TODO(), secret.Name, metav1.DeleteOptions{})
+		}
+	} else {
+		configMap, err := clientset.CoreV1().ConfigMaps(job.Namespace).Create(context.TODO(), &corev1.ConfigMap{ObjectMeta: meta, BinaryData: data}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create the config map of the files: %s", err.Error())
+		}
+		setOwner = func(owner metav1.OwnerReference) error {
+			configMap.OwnerReferences = []metav1.OwnerReference{owner}
+			_, err := clientset.CoreV1().ConfigMaps(job.Namespace).Update(context.TODO(), configMap, metav1.UpdateOptions{})
+			return err
+		}
+		deleteFiles = func() error {
+			return clientset.CoreV1().ConfigMaps(job.Namespace).Delete(context.TODO(), configMap.Name, metav1.DeleteOptions{})
+		}
+	}
+
+	created, err := clientset.BatchV1().Jobs(job.Namespace).Create(context.TODO(), job, metav1.CreateOptions{})
+	if err != nil {
+		_ = deleteFiles()
+		return nil, err
+	}
+	err = setOwner(metav1.OwnerReference{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Name:       created.Name,
+		UID:        created.UID,
+	})
+	if err != nil {
+		yellow.Printf("Couldn't make the job own its files, delete %s/%s once it's done: %s\n", job.Namespace, getJobFilesName(job), err.Error())
+	}
+	return created, nil
+}
+
+// getJobFiles reads the uploaded files of a job back from its ConfigMap or Secret, so that the job
+// can be rerun.
+func getJobFiles(clientset *kubernetes.Clientset, job *batchv1.Job, opts *JobOptions) error {
+	annotation, ok := job.Annotations[FilesAnnotationKey]
+	if !ok {
+		return nil
+	}
+	files := []JobFile{}
+	if err := json.Unmarshal([]byte(annotation), &files); err != nil {
+		return fmt.Errorf("invalid annotation %s: %s", FilesAnnotationKey, err.Error())
+	}
+
+	var data map[string][]byte
+	opts.SecretFiles = job.Annotations[SecretFilesAnnotationKey] == "true"
+	if opts.SecretFiles {
+		secret, err := clientset.CoreV1().Secrets(job.Namespace).Get(context.TODO(), getJobFilesName(job), metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("couldn't read the job's files: %s", err.Error())
+		}
+		data = secret.Data
+	} else {
+		configMap, err := clientset.CoreV1().ConfigMaps(job.Namespace).Get(context.TODO(), getJobFilesName(job), metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("couldn't read the job's files: %s", err.Error())
+		}
+		data = configMap.BinaryData
+	}
+	for i := range files {
+		files[i].Data = data[getJobFileKey(i)]
+	}
+	opts.Files = files
+	return nil
+}
+
+func printFiles(opts *JobOptions) {
+	for _, f := range opts.Files {
+		printAttribute("File", fmt.Sprintf("%s -> %s (%d bytes)", f.LocalPath, f.RemotePath, len(f.Data)))
+	}
+}
@@ -0,0 +1,155 @@
+package jobify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// gcStates are the states of the jobs that can be deleted, active and pending jobs never are.
+var gcStates = []string{"completed", "failed", "cancelled", "rejected"}
+
+// GCOptions selects the jobs that jobify gc deletes.
+type GCOptions struct {
+	Namespace   string
+	OlderThan   time.Duration
+	States      []string
+	Deployments []string
+	KeepLast    int
+	Archive     string
+	Confirm     bool
+}
+
+func checkGCOptions(opts *GCOptions) error {
+	for _, s := range opts.States {
+		if !containsString(gcStates, s) {
+			return fmt.Errorf("invalid state %s, it must be one of %s", s, strings.Join(gcStates, ", "))
+		}
+	}
+	if opts.KeepLast < 0 {
+		return fmt.Errorf("--keep-last can't be negative")
+	}
+	if opts.Archive != "" {
+		return checkLogArchiveDestination(opts.Archive)
+	}
+	return nil
+}
+
+// selectGCJobs returns the jobs to delete, oldest first. The newest --keep-last jobs of every
+// deployment that match the other filters are kept whatever their age.
+func selectGCJobs(jobs []batchv1.Job, opts *GCOptions, now time.Time) []batchv1.Job {
+	states := opts.States
+	if len(states) == 0 {
+		states = gcStates
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreationTimestamp.After(jobs[j].CreationTimestamp.Time)
+	})
+
+	selected := []batchv1.Job{}
+	kept := map[string]int{}
+	for _, j := range jobs {
+		state, _ := getJobState(&j)
+		if !containsString(states, strings.ToLower(state)) {
+			continue
+		}
+		deployment := j.Annotations[SourceDeploymentAnnotationKey]
+		if len(opts.Deployments) > 0 && !containsString(opts.Deployments, deployment) && !containsString(opts.Deployments, j.Annotations[SourceAliasAnnotationKey]) {
+			continue
+		}
+		key := j.Namespace + "/" + deployment
+		if kept[key] < opts.KeepLast {
+			kept[key]++
+			continue
+		}
+		if now.Sub(j.CreationTimestamp.Time) < opts.OlderThan {
+			continue
+		}
+		selected = append(selected, j)
+	}
+	for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+		selected[i], selected[j] = selected[j], selected[i]
+	}
+	return selected
+}
+
+func printGCTable(jobs []batchv1.Job, now time.Time) {
+	w := tabwriter.NewWriter(color.Output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tDEPLOYMENT\tSTATE\tAGE")
+	for _, j := range jobs {
+		state, _ := getJobState(&j)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", j.Namespace, j.Name, j.Annotations[SourceDeploymentAnnotationKey], state,
+			duration.HumanDuration(now.Sub(j.CreationTimestamp.Time)))
+	}
+	w.Flush()
+}
+
+// archiveJob saves the job's spec and the logs of its remaining pods before it's deleted.
+func archiveJob(clientset *kubernetes.Clientset, job *batchv1.Job, destination string) (string, error) {
+	job.APIVersion = "batch/v1"
+	job.Kind = "Job"
+	spec, err := yaml.Marshal(job)
+	if err != nil {
+		return "", err
+	}
+	location, _, err := saveJobArchive(clientset, job, destination, map[string][]byte{"job.yaml": spec})
+	return location, err
+}
+
+// gc deletes old jobify jobs, or only lists them unless it's confirmed.
+func gc(clientset *kubernetes.Clientset, opts *GCOptions) {
+	fmt.Println("Loading jobs...")
+	jobList, err := clientset.BatchV1().Jobs(opts.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "jobify=true",
+	})
+	if err != nil {
+		fmt.Printf("Error listing jobs: %s\n", err.Error())
+		os.Exit(1)
+	}
+	now := time.Now()
+	jobs := selectGCJobs(jobList.Items, opts, now)
+	if len(jobs) == 0 {
+		fmt.Println("No jobs to delete")
+		return
+	}
+	printGCTable(jobs, now)
+	fmt.Println()
+	if !opts.Confirm {
+		faint.Printf("Dry run, run again with --confirm to delete these %d jobs\n", len(jobs))
+		return
+	}
+
+	deleted := 0
+	for i := range jobs {
+		job := &jobs[i]
+		if opts.Archive != "" {
+			location, err := archiveJob(clientset, job, opts.Archive)
+			if err != nil {
+				red.Printf("Couldn't archive %s/%s, it wasn't deleted: %s\n", job.Namespace, job.Name, err.Error())
+				continue
+			}
+			faint.Printf("Archived %s/%s to %s\n", job.Namespace, job.Name, location)
+		}
+		if err := deleteJob(clientset, job); err != nil {
+			red.Printf("Couldn't delete %s/%s: %s\n", job.Namespace, job.Name, err.Error())
+			continue
+		}
+		fmt.Printf("Deleted %s/%s\n", job.Namespace, job.Name)
+		deleted++
+	}
+	fmt.Printf("Deleted %d of %d jobs\n", deleted, len(jobs))
+	if deleted < len(jobs) {
+		os.Exit(1)
+	}
+}
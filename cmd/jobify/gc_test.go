@@ -0,0 +1,115 @@
+package jobify
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var gcTestNow = time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+// newGCTestJob returns a job of the default namespace created the given time before gcTestNow.
+func newGCTestJob(name, deployment, state string, age time.Duration) batchv1.Job {
+	job := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(gcTestNow.Add(-age)),
+			Annotations: map[string]string{
+				SourceDeploymentAnnotationKey: deployment,
+				SourceAliasAnnotationKey:      deployment + "-alias",
+			},
+		},
+	}
+	switch state {
+	case "completed":
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	case "failed":
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	case "cancelled":
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+		job.Annotations[CancelledAnnotationKey] = "true"
+	case "rejected":
+		job.Annotations[ApprovalAnnotationKey] = ApprovalRejected
+	case "pending":
+		job.Annotations[ApprovalAnnotationKey] = ApprovalPending
+	}
+	return job
+}
+
+func TestSelectGCJobs(t *testing.T) {
+	day := 24 * time.Hour
+	jobs := []batchv1.Job{
+		newGCTestJob("web-new", "web", "completed", time.Hour),
+		newGCTestJob("web-old", "web", "completed", 10*day),
+		newGCTestJob("web-older", "web", "failed", 20*day),
+		newGCTestJob("web-cancelled", "web", "cancelled", 5*day),
+		newGCTestJob("web-active", "web", "active", 30*day),
+		newGCTestJob("web-pending", "web", "pending", 30*day),
+		newGCTestJob("worker-old", "worker", "rejected", 15*day),
+		newGCTestJob("worker-new", "worker", "completed", 2*day),
+	}
+
+	tests := []struct {
+		name string
+		opts *GCOptions
+		want []string
+	}{
+		{
+			name: "every finished job, oldest first",
+			opts: &GCOptions{},
+			want: []string{"web-older", "worker-old", "web-old", "web-cancelled", "worker-new", "web-new"},
+		},
+		{
+			name: "older than",
+			opts: &GCOptions{OlderThan: 7 * day},
+			want: []string{"web-older", "worker-old", "web-old"},
+		},
+		{
+			name: "states",
+			opts: &GCOptions{States: []string{"failed", "rejected"}},
+			want: []string{"web-older", "worker-old"},
+		},
+		{
+			name: "deployment by name or alias",
+			opts: &GCOptions{Deployments: []string{"worker-alias"}},
+			want: []string{"worker-old", "worker-new"},
+		},
+		{
+			name: "keep the newest jobs of every deployment",
+			opts: &GCOptions{KeepLast: 2},
+			want: []string{"web-older", "web-old"},
+		},
+		{
+			name: "kept jobs are counted among the jobs of the selected states",
+			opts: &GCOptions{KeepLast: 1, States: []string{"completed"}},
+			want: []string{"web-old"},
+		},
+		{
+			name: "kept jobs are counted before the age filter",
+			opts: &GCOptions{KeepLast: 1, OlderThan: 12 * day},
+			want: []string{"web-older", "worker-old"},
+		},
+		{
+			name: "keeping more jobs than there are",
+			opts: &GCOptions{KeepLast: 10},
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, j := range selectGCJobs(append([]batchv1.Job{}, jobs...), tt.opts, gcTestNow) {
+				got = append(got, j.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectGCJobs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
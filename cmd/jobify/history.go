@@ -0,0 +1,298 @@
+package jobify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/homedir"
+)
+
+const (
+	HistoryStateActive    = "Active"
+	HistoryStateCompleted = "Completed"
+	HistoryStateFailed    = "Failed"
+	HistoryStateCancelled = "Cancelled"
+	HistoryStateDeleted   = "Deleted"
+)
+
+type HistoryEntry struct {
+	ID              string     `json:"id"`
+	Context         string     `json:"context"`
+	Cluster         string     `json:"cluster"`
+	Namespace       string     `json:"namespace"`
+	JobName         string     `json:"jobName"`
+	Deployment      string     `json:"deployment"`
+	DeploymentAlias string     `json:"deploymentAlias"`
+	Command         string     `json:"command"`
+	ImageTag        string     `json:"imageTag"`
+	CreatedBy       string     `json:"createdBy"`
+	Reason          string     `json:"reason,omitempty"`
+	Parallelism     int32      `json:"parallelism,omitempty"`
+	Completions     int32      `json:"completions,omitempty"`
+	Indexed         bool       `json:"indexed,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	FinishedAt      *time.Time `json:"finishedAt,omitempty"`
+	State           string     `json:"state"`
+}
+
+func getHistoryDir() string {
+	return filepath.Join(homedir.HomeDir(), ".jobify", "history")
+}
+
+// recordJobHistory saves a newly created job of a kubeconfig context to the local history. Failing
+// to do so doesn't fail the job creation, since the job already exists at this point.
+func recordJobHistory(job *batchv1.Job, contextName string) {
+	cluster := ""
+	if config, err := getClientConfigForContext(contextName).ClientConfig(); err == nil {
+		cluster = config.Host
+	}
+	entry := &HistoryEntry{
+		ID:              randomString(8),
+		Context:         contextName,
+		Cluster:         cluster,
+		Namespace:       job.Namespace,
+		JobName:         job.Name,
+		Deployment:      job.Annotations[SourceDeploymentAnnotationKey],
+		DeploymentAlias: job.Annotations[SourceAliasAnnotationKey],
+		Command:         job.Annotations[UserCommandAnnotationKey],
+		ImageTag:        getJobImageTag(job),
+		CreatedBy:       job.Annotations[CreatedByAnnotationKey],
+		Reason:          job.Annotations[ReasonAnnotationKey],
+		Indexed:         isJobIndexed(job),
+		CreatedAt:       time.Now(),
+		State:           HistoryStateActive,
+	}
+	if job.Spec.Parallelism != nil {
+		entry.Parallelism = *job.Spec.Parallelism
+	}
+	if job.Spec.Completions != nil {
+		entry.Completions = *job.Spec.Completions
+	}
+	if err := saveHistoryEntry(entry); err != nil {
+		faint.Printf("Couldn't save the job to the local history: %s\n", err.Error())
+	}
+}
+
+func saveHistoryEntry(entry *HistoryEntry) error {
+	if err := os.MkdirAll(getHistoryDir(), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(getHistoryDir(), entry.ID+".json"), data, 0600)
+}
+
+func loadHistoryEntry(id string) (*HistoryEntry, error) {
+	data, err := ioutil.ReadFile(filepath.Join(getHistoryDir(), filepath.Base(id)+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no history entry with ID %s", id)
+	} else if err != nil {
+		return nil, err
+	}
+	entry := &HistoryEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// loadHistory returns every history entry, newest first.
+func loadHistory() ([]*HistoryEntry, error) {
+	files, err := ioutil.ReadDir(getHistoryDir())
+	if os.IsNotExist(err) {
+		return []*HistoryEntry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	entries := []*HistoryEntry{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		entry, err := loadHistoryEntry(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			faint.Printf("Skipping unreadable history entry %s: %s\n", f.Name(), err.Error())
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+	return entries, nil
+}
+
+// refreshHistoryStates looks up the jobs of unfinished entries to record their final state.
+// Clusters that can't be reached are skipped so that the history stays usable offline.
+func refreshHistoryStates(entries []*HistoryEntry) {
+	clients := map[string]*kubernetes.Clientset{}
+
+	for _, entry := range entries {
+		if entry.State != HistoryStateActive {
+			continue
+		}
+		clientset, ok := clients[entry.Context]
+		if !ok {
+			clientset, _ = getClientForContext(entry.Context)
+			clients[entry.Context] = clientset
+		}
+		if clientset == nil {
+			continue
+		}
+
+		job, err := clientset.BatchV1().Jobs(entry.Namespace).Get(context.TODO(), entry.JobName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			entry.State = HistoryStateDeleted
+		} else if err != nil {
+			continue
+		} else {
+			entry.State = getHistoryState(job)
+			if entry.State != HistoryStateActive {
+				finishedAt := time.Now()
+				if job.Status.CompletionTime != nil {
+					finishedAt = job.Status.CompletionTime.Time
+				}
+				for _, c := range job.Status.Conditions {
+					if c.Type == batchv1.JobFailed {
+						finishedAt = c.LastTransitionTime.Time
+					}
+				}
+				entry.FinishedAt = &finishedAt
+			}
+		}
+		if entry.State != HistoryStateActive {
+			_ = saveHistoryEntry(entry)
+		}
+	}
+}
+
+func getHistoryState(job *batchv1.Job) string {
+	completed, failed := checkJobCondition(job)
+	if completed {
+		return HistoryStateCompleted
+	} else if failed && job.Annotations[CancelledAnnotationKey] == "true" {
+		return HistoryStateCancelled
+	} else if failed {
+		return HistoryStateFailed
+	}
+	return HistoryStateActive
+}
+
+func searchHistory(entries []*HistoryEntry, terms []string) []*HistoryEntry {
+	matches := []*HistoryEntry{}
+	for _, entry := range entries {
+		searchable := strings.ToLower(strings.Join([]string{
+			entry.ID, entry.Context, entry.Namespace, entry.JobName, entry.Deployment,
+			entry.DeploymentAlias, entry.Command, entry.ImageTag, entry.CreatedBy, entry.Reason, entry.State,
+		}, " "))
+		matched := true
+		for _, term := range terms {
+			if !strings.Contains(searchable, strings.ToLower(term)) {
+				matched = false
+			}
+		}
+		if matched {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
+func history(terms []string, limit int) {
+	entries, err := loadHistory()
+	if err != nil {
+		fmt.Printf("Error reading history: %s\n", err.Error())
+		os.Exit(1)
+	}
+	entries = searchHistory(entries, terms)
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	if len(entries) == 0 {
+		fmt.Println("No jobs found in the history")
+		return
+	}
+	refreshHistoryStates(entries)
+
+	for _, entry := range entries {
+		printHistoryEntry(entry)
+	}
+	fmt.Println()
+	faint.Println("Use the following command to create a job again from one of the entries:")
+	cyan.Println("jobify history rerun <id>")
+}
+
+func printHistoryEntry(entry *HistoryEntry) {
+	icon := "⏳"
+	switch entry.State {
+	case HistoryStateCompleted:
+		icon = "✅"
+	case HistoryStateFailed:
+		icon = "❌"
+	case HistoryStateCancelled:
+		icon = "🚫"
+	case HistoryStateDeleted:
+		icon = "🗑"
+	}
+	icon = emoji(icon)
+	cyan.Printf("%s ", entry.ID)
+	faint.Printf("%s ", entry.CreatedAt.Format("2006-01-02 15:04"))
+	fmt.Printf("%s %s/%s: %s ", icon, entry.Namespace, entry.JobName, entry.Command)
+	faint.Printf("(deployment: %s, image tag: %s, context: %s)\n", entry.DeploymentAlias, entry.ImageTag, entry.Context)
+}
+
+func rerunHistoryEntry(id string, force bool) {
+	entry, err := loadHistoryEntry(id)
+	if err != nil {
+		fmt.Printf("Error reading history: %s\n", err.Error())
+		os.Exit(1)
+	}
+	// The job is created in the context it was first created in, unless --context is given
+	if kubeContext == "" {
+		kubeContext = entry.Context
+	}
+	clientset := getClient()
+
+	fmt.Println("Loading deployment...")
+	deployment, err := clientset.AppsV1().Deployments(entry.Namespace).Get(context.TODO(), entry.Deployment, metav1.GetOptions{})
+	if err != nil {
+		fmt.Printf("Error getting deployment: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if err := validateDeployment(deployment); err != nil {
+		fmt.Printf("Invalid deployment: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	opts := &JobOptions{
+		Command:          entry.Command,
+		ImageTagOverride: entry.ImageTag,
+		Reason:           entry.Reason,
+		CreatedBy:        getCurrentUser(clientset),
+		Force:            force,
+		Parallelism:      entry.Parallelism,
+		Completions:      entry.Completions,
+		Indexed:          entry.Indexed,
+	}
+	if !promptConfirmation(deployment, opts, getJobChecks(clientset, deployment)) {
+		fmt.Println("Cancelled job creation, terminating...")
+		return
+	}
+
+	job := setupJob(deployment, opts)
+	submitJob(clientset, deployment, opts, job)
+}
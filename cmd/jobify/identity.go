@@ -0,0 +1,90 @@
+package jobify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+var currentUser string
+
+type selfSubjectReview struct {
+	Status struct {
+		UserInfo struct {
+			Username string `json:"username"`
+		} `json:"userInfo"`
+	} `json:"status"`
+}
+
+// getCurrentUser identifies who is running jobify. The identity the cluster authenticates is
+// preferred, falling back to the git config, the kubeconfig user and finally the OS user.
+func getCurrentUser(clientset *kubernetes.Clientset) string {
+	if currentUser != "" {
+		return currentUser
+	}
+	for _, lookup := range []func() string{
+		func() string { return getClusterUser(clientset) },
+		getGitUser,
+		getKubeconfigUser,
+		getOSUser,
+	} {
+		if name := lookup(); name != "" {
+			currentUser = name
+			return currentUser
+		}
+	}
+	return "unknown"
+}
+
+// getClusterUser asks the API server who we are through a SelfSubjectReview, which clusters older
+// than 1.27 don't support.
+func getClusterUser(clientset *kubernetes.Clientset) string {
+	for _, version := range []string{"v1", "v1beta1"} {
+		body := fmt.Sprintf(`{"apiVersion":"authentication.k8s.io/%s","kind":"SelfSubjectReview"}`, version)
+		result, err := clientset.AuthenticationV1().RESTClient().Post().
+			AbsPath("/apis/authentication.k8s.io/"+version+"/selfsubjectreviews").
+			SetHeader("Content-Type", "application/json").
+			Body([]byte(body)).
+			DoRaw(context.TODO())
+		if err != nil {
+			continue
+		}
+		review := &selfSubjectReview{}
+		if err := json.Unmarshal(result, review); err == nil && review.Status.UserInfo.Username != "" {
+			return review.Status.UserInfo.Username
+		}
+	}
+	return ""
+}
+
+func getGitUser() string {
+	output, err := exec.Command("git", "config", "user.email").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func getKubeconfigUser() string {
+	rawConfig, err := getClientConfig().RawConfig()
+	if err != nil {
+		return ""
+	}
+	if c, ok := rawConfig.Contexts[getContextName()]; ok {
+		return c.AuthInfo
+	}
+	return ""
+}
+
+func getOSUser() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
@@ -9,13 +9,16 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	appv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
@@ -30,12 +33,44 @@ const (
 	SourceDeploymentAnnotationKey = "jobify/source-deployment"
 	DeploymentAliasAnnotationKey  = "jobify/deployment-alias"
 	LogsURLTemplateAnnotationKey  = "jobify/log-url-template"
+	CancelledAnnotationKey        = "jobify/cancelled"
+	CreatedByAnnotationKey        = "jobify/created-by"
+	ReasonAnnotationKey           = "jobify/reason"
+	RequireReasonAnnotationKey    = "jobify/require-reason"
+	RequiresApprovalAnnotationKey = "jobify/requires-approval"
+	ApprovalAnnotationKey         = "jobify/approval"
+	ApprovedByAnnotationKey       = "jobify/approved-by"
+	RejectedByAnnotationKey       = "jobify/rejected-by"
+	CreatorIdentityAnnotationKey  = "jobify/creator-identity"
 )
 
-func getClient() *kubernetes.Clientset {
+// kubeContext overrides the current context of the kubeconfig when it's set.
+var kubeContext string
+
+// getKubeContext returns the context that overrides the current one, from the flag or the config.
+func getKubeContext() string {
+	if kubeContext != "" {
+		return kubeContext
+	}
+	return userConfig.Context
+}
+
+func getClientConfig() clientcmd.ClientConfig {
+	return getClientConfigForContext(getKubeContext())
+}
+
+// getClientConfigForContext returns the config of a kubeconfig context, or of the current context
+// when the name is empty.
+func getClientConfigForContext(contextName string) clientcmd.ClientConfig {
 	kubeconfig := filepath.Join(homedir.HomeDir(), ".kube", "config")
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig},
+		&clientcmd.ConfigOverrides{CurrentContext: contextName},
+	)
+}
 
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+func getClient() *kubernetes.Clientset {
+	config, err := getClientConfig().ClientConfig()
 	if err != nil {
 		fmt.Printf("Error creating Kubernetes config object: %s\n", err.Error())
 		os.Exit(1)
@@ -51,6 +86,25 @@ func getClient() *kubernetes.Clientset {
 	return c
 }
 
+func getClientForContext(contextName string) (*kubernetes.Clientset, error) {
+	config, err := getClientConfigForContext(contextName).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+func getContextName() string {
+	if getKubeContext() != "" {
+		return getKubeContext()
+	}
+	rawConfig, err := getClientConfig().RawConfig()
+	if err != nil {
+		return ""
+	}
+	return rawConfig.CurrentContext
+}
+
 func getJob(clientset *kubernetes.Clientset, namespace, name string) *batchv1.Job {
 	job, err := clientset.BatchV1().Jobs(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
@@ -74,8 +128,7 @@ func getActiveContainerStateString(containerState corev1.ContainerState) string
 
 }
 
-func getPodLogs(clientset *kubernetes.Clientset, pod *corev1.Pod, containerName string) string {
-	tailLines := int64(10)
+func getPodLogs(clientset *kubernetes.Clientset, pod *corev1.Pod, containerName string, tailLines int64) (string, error) {
 	req := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
 		TailLines: &tailLines,
 		Container: containerName,
@@ -83,25 +136,21 @@ func getPodLogs(clientset *kubernetes.Clientset, pod *corev1.Pod, containerName
 
 	podLogs, err := req.Stream(context.TODO())
 	if err != nil {
-		fmt.Printf("Error getting logs: %s\n", err.Error())
-		os.Exit(1)
+		return "", err
 	}
 	defer podLogs.Close()
 
 	buf := new(bytes.Buffer)
 	_, err = io.Copy(buf, podLogs)
 	if err != nil {
-		fmt.Printf("Error reading logs: %s\n", err.Error())
-		os.Exit(1)
+		return "", err
 	}
 	str := buf.String()
-	return str
+	return str, nil
 }
 
 func getJobPods(clientset *kubernetes.Clientset, job *batchv1.Job) *corev1.PodList {
-	podList, err := clientset.CoreV1().Pods(job.Namespace).List(context.TODO(), metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("job-name=%s", job.Name),
-	})
+	podList, err := listJobPods(clientset, job)
 	if err != nil {
 		fmt.Printf("Error getting job pods: %s\n", err.Error())
 		os.Exit(1)
@@ -109,10 +158,55 @@ func getJobPods(clientset *kubernetes.Clientset, job *batchv1.Job) *corev1.PodLi
 	return podList
 }
 
-func getJobifyJobs(clientset *kubernetes.Clientset) *batchv1.JobList {
-	jobs, err := clientset.BatchV1().Jobs("").List(context.TODO(), metav1.ListOptions{
-		LabelSelector: "jobify=true",
+func listJobPods(clientset *kubernetes.Clientset, job *batchv1.Job) (*corev1.PodList, error) {
+	return clientset.CoreV1().Pods(job.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%s", job.Name),
 	})
+}
+
+func getJobEvents(clientset *kubernetes.Clientset, job *batchv1.Job, podList *corev1.PodList) []corev1.Event {
+	events, err := listJobEvents(clientset, job, podList)
+	if err != nil {
+		fmt.Printf("Error getting job events: %s\n", err.Error())
+		os.Exit(1)
+	}
+	return events
+}
+
+func listJobEvents(clientset *kubernetes.Clientset, job *batchv1.Job, podList *corev1.PodList) ([]corev1.Event, error) {
+	selectors := []string{fmt.Sprintf("involvedObject.kind=Job,involvedObject.name=%s", job.Name)}
+	for _, p := range podList.Items {
+		selectors = append(selectors, fmt.Sprintf("involvedObject.kind=Pod,involvedObject.name=%s", p.Name))
+	}
+
+	events := []corev1.Event{}
+	for _, selector := range selectors {
+		eventList, err := clientset.CoreV1().Events(job.Namespace).List(context.TODO(), metav1.ListOptions{
+			FieldSelector: selector,
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, eventList.Items...)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return getEventTime(&events[i]).Before(getEventTime(&events[j]))
+	})
+	return events, nil
+}
+
+func getEventTime(event *corev1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	} else if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.FirstTimestamp.Time
+}
+
+func getJobifyJobs(clientset *kubernetes.Clientset) *batchv1.JobList {
+	jobs, err := listJobifyJobs(clientset, userConfig.Namespace)
 	if err != nil {
 		fmt.Printf("Error listing jobs: %s\n", err.Error())
 		os.Exit(1)
@@ -120,15 +214,58 @@ func getJobifyJobs(clientset *kubernetes.Clientset) *batchv1.JobList {
 	return jobs
 }
 
-func setupCommandArray(deployment *appv1.Deployment, userCommand string) (commandArray []string) {
+func listJobifyJobs(clientset *kubernetes.Clientset, namespace string) (*batchv1.JobList, error) {
+	return clientset.BatchV1().Jobs(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "jobify=true",
+	})
+}
+
+// setupCommandArray inserts the command in the deployment's JSON command template, escaped like a
+// JSON string so that quotes and backslashes in the command are kept as they are.
+func setupCommandArray(deployment *appv1.Deployment, userCommand string) ([]string, error) {
 	commandTemplate := deployment.Annotations[CommandTemplateAnnotationKey]
-	commandArrayString := strings.Replace(commandTemplate, "$JOBIFY_COMMAND", userCommand, -1)
+	commandArrayString := strings.Replace(commandTemplate, "$JOBIFY_COMMAND", escapeJSONString(userCommand), -1)
 	var arr []string
-	_ = json.Unmarshal([]byte(commandArrayString), &arr)
-	return arr
+	if err := json.Unmarshal([]byte(commandArrayString), &arr); err != nil {
+		return nil, err
+	}
+	for i := range arr {
+		arr[i] = strings.Replace(arr[i], IndexPlaceholder, "$("+CompletionIndexEnvVar+")", -1)
+		arr[i] = strings.Replace(arr[i], FilesDirPlaceholder, DefaultFilesDir, -1)
+	}
+	return arr, nil
+}
+
+// escapeJSONString escapes a value to be placed between the quotes of a JSON string.
+func escapeJSONString(value string) string {
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(value)
+	escaped := strings.TrimSuffix(buf.String(), "\n")
+	return escaped[1 : len(escaped)-1]
+}
+
+// checkCommandTemplate makes sure the command results in a valid command array once it's inserted in
+// the deployment's command template, rather than running the image's default command.
+func checkCommandTemplate(deployment *appv1.Deployment, opts *JobOptions) []string {
+	if _, err := setupCommandArray(deployment, opts.Command); err != nil {
+		return []string{fmt.Sprintf("The command can't be inserted in the command template %s: %s", CommandTemplateAnnotationKey, err.Error())}
+	}
+	return []string{}
 }
 
 func validateDeployment(deployment *appv1.Deployment) error {
+	if _, err := getDeploymentPlacement(deployment); err != nil {
+		return err
+	}
+	if _, err := getDeploymentPodOverrides(deployment); err != nil {
+		return err
+	}
+	if _, err := getLinkTemplates(deployment.Annotations); err != nil {
+		return err
+	}
+
 	_, ok := deployment.Annotations[CommandTemplateAnnotationKey]
 	if !ok {
 		return errors.New("Deployment doesn't have command template annotation " + CommandTemplateAnnotationKey)
@@ -152,9 +289,7 @@ func validateDeployment(deployment *appv1.Deployment) error {
 }
 
 func getJobifyDeployments(clientset *kubernetes.Clientset) *appv1.DeploymentList {
-	deployments, err := clientset.AppsV1().Deployments("").List(context.TODO(), metav1.ListOptions{
-		LabelSelector: "jobify=true",
-	})
+	deployments, err := listJobifyDeployments(clientset, userConfig.Namespace)
 	if err != nil {
 		fmt.Printf("Error listing deployments: %s\n", err.Error())
 		os.Exit(1)
@@ -162,7 +297,48 @@ func getJobifyDeployments(clientset *kubernetes.Clientset) *appv1.DeploymentList
 	return deployments
 }
 
-func setupJob(deployment *appv1.Deployment, commandArray []string, imageTagOverride string, userCommand string) *batchv1.Job {
+func listJobifyDeployments(clientset *kubernetes.Clientset, namespace string) (*appv1.DeploymentList, error) {
+	return clientset.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "jobify=true",
+	})
+}
+
+// JobOptions holds the choices the user makes when creating a job from a deployment.
+type JobOptions struct {
+	Command           string
+	ImageTagOverride  string
+	Reason            string
+	CreatedBy         string
+	DeadlineSeconds   int64
+	Force             bool
+	Parallelism       int32
+	Completions       int32
+	Indexed           bool
+	ForEachItems      []string
+	ForEachRate       float64
+	Preset            string
+	PresetParams      map[string]string
+	Env               map[string]string
+	Resources         *corev1.ResourceRequirements
+	Contexts          []string
+	Placement         *Placement
+	DropContainers    []string
+	TerminateSidecars bool
+	PodOverrides      *PodOverrides
+	CollectPath       string
+	Files             []JobFile
+	SecretFiles       bool
+	ArchiveLogs       string
+	Notify            []NotifyTarget
+}
+
+func requiresReason(deployment *appv1.Deployment) bool {
+	return deployment.Annotations[RequireReasonAnnotationKey] == "true"
+}
+
+func setupJob(deployment *appv1.Deployment, opts *JobOptions) *batchv1.Job {
+	// The command array is checked by checkCommandTemplate before jobs are set up
+	commandArray, _ := setupCommandArray(deployment, opts.Command)
 
 	jobName := getDeploymentName(deployment) + "-" + randomString(5)
 
@@ -175,13 +351,13 @@ func setupJob(deployment *appv1.Deployment, commandArray []string, imageTagOverr
 
 	primaryContainerIndex := getPrimaryContainer(deployment)
 
-	if imageTagOverride != "" {
+	if opts.ImageTagOverride != "" {
 		oldImageName := jobTemplate.Spec.Containers[primaryContainerIndex].Image
 		colonIndex := strings.Index(oldImageName, ":")
 		if colonIndex == -1 {
 			colonIndex = len(oldImageName)
 		}
-		newImageName := oldImageName[0:colonIndex] + ":" + imageTagOverride
+		newImageName := oldImageName[0:colonIndex] + ":" + opts.ImageTagOverride
 		jobTemplate.Spec.Containers[primaryContainerIndex].Image = newImageName
 	}
 
@@ -191,7 +367,7 @@ func setupJob(deployment *appv1.Deployment, commandArray []string, imageTagOverr
 	}
 
 	jobTemplate.Spec.Containers[primaryContainerIndex].Command = commandArray
-	activeDeadlineSeconds := int64(24 * 60 * 60)
+	activeDeadlineSeconds := getDeadlineSeconds(opts)
 	backoffLimit := int32(2)
 
 	job := &batchv1.Job{
@@ -205,8 +381,9 @@ func setupJob(deployment *appv1.Deployment, commandArray []string, imageTagOverr
 			Annotations: map[string]string{
 				SourceDeploymentAnnotationKey: fmt.Sprintf("%s", deployment.Name),
 				SourceAliasAnnotationKey:      getDeploymentName(deployment),
-				UserCommandAnnotationKey:      userCommand,
+				UserCommandAnnotationKey:      opts.Command,
 				PrimaryContainerAnnotationKey: jobTemplate.Spec.Containers[primaryContainerIndex].Name,
+				CreatedByAnnotationKey:        opts.CreatedBy,
 			},
 		},
 		Spec: batchv1.JobSpec{
@@ -215,27 +392,126 @@ func setupJob(deployment *appv1.Deployment, commandArray []string, imageTagOverr
 			Template:              *jobTemplate,
 		},
 	}
+	setupJobCompletions(job, primaryContainerIndex, opts)
+	setupJobOverrides(job, primaryContainerIndex, opts)
+	setupJobPlacement(job, deployment, opts)
+	setupJobPodOverrides(job, deployment, opts)
+	setupJobFiles(job, opts)
+	setupJobCollect(job, opts)
+	setupJobSidecars(job, deployment, opts)
 
 	if logURLTemplate, ok := deployment.Annotations[LogsURLTemplateAnnotationKey]; ok {
 		job.Annotations[LogsURLTemplateAnnotationKey] = logURLTemplate
 	}
+	if linkTemplates, ok := deployment.Annotations[LinkTemplatesAnnotationKey]; ok {
+		job.Annotations[LinkTemplatesAnnotationKey] = linkTemplates
+	}
+	if opts.Reason != "" {
+		job.Annotations[ReasonAnnotationKey] = opts.Reason
+	}
+	if requiresApproval(deployment) {
+		suspend := true
+		job.Spec.Suspend = &suspend
+		job.Annotations[ApprovalAnnotationKey] = ApprovalPending
+	}
 
 	return job
 }
 
-func createJob(clientset *kubernetes.Clientset, job *batchv1.Job) {
+// createJob creates a job and prints how to follow it. Errors are returned rather than exiting, so
+// that the caller's cleanup still runs.
+func createJob(clientset *kubernetes.Clientset, job *batchv1.Job, opts *JobOptions) error {
 	fmt.Println("Creating job...")
-	_, err := clientset.BatchV1().Jobs(job.Namespace).Create(context.TODO(), job, metav1.CreateOptions{})
-
-	if err != nil {
-		fmt.Printf("Error creating job: %s\n", err.Error())
-		os.Exit(1)
+	if _, err := createJobResource(clientset, job, opts); err != nil {
+		return err
 	}
 
 	fmt.Printf("Created job %s/%s successfully!\n", job.Namespace, job.Name)
+	recordJobHistory(job, getContextName())
 	fmt.Println()
+	if getApprovalState(job) == ApprovalPending {
+		color.New(color.FgYellow).Println("The deployment requires approval, the job won't start until someone else approves it with:")
+		color.New(color.FgCyan).Printf("jobify approve %s %s\n", job.Namespace, job.Name)
+		fmt.Println()
+	}
 	color.New(color.Faint).Println("Use the following command to view the job's details:")
 	color.New(color.FgCyan).Printf("jobify view %s %s\n", job.Namespace, job.Name)
+	return nil
+}
+
+// rerunJob creates a new job from the same deployment, command and image tag as an existing one,
+// once it has passed the same checks as a new job. Progress messages are passed to notify.
+func rerunJob(clientset *kubernetes.Clientset, job *batchv1.Job, notify func(string)) (*batchv1.Job, error) {
+	deploymentName, ok := job.Annotations[SourceDeploymentAnnotationKey]
+	if !ok {
+		return nil, errors.New("Job doesn't have source deployment annotation " + SourceDeploymentAnnotationKey)
+	}
+	deployment, err := clientset.AppsV1().Deployments(job.Namespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDeployment(deployment); err != nil {
+		return nil, err
+	}
+
+	opts := &JobOptions{
+		Command:          job.Annotations[UserCommandAnnotationKey],
+		ImageTagOverride: getJobImageTag(job),
+		Reason:           job.Annotations[ReasonAnnotationKey],
+		CreatedBy:        getCurrentUser(clientset),
+		Indexed:          isJobIndexed(job),
+	}
+	if job.Spec.Parallelism != nil {
+		opts.Parallelism = *job.Spec.Parallelism
+	}
+	if job.Spec.Completions != nil {
+		opts.Completions = *job.Spec.Completions
+	}
+	getJobOverrides(job, opts)
+	getJobPlacementOverrides(job, opts)
+	getJobSidecarOverrides(job, opts)
+	getJobPodOverrides(job, opts)
+	opts.CollectPath = job.Annotations[CollectAnnotationKey]
+	if err := getJobFiles(clientset, job, opts); err != nil {
+		return nil, err
+	}
+	checks, err := loadJobChecks(clientset, deployment)
+	if err != nil {
+		return nil, err
+	}
+	if problems, _ := checks(opts); len(problems) > 0 {
+		return nil, errors.New("the job can't be created: " + strings.Join(problems, "; "))
+	}
+
+	newJob := setupJob(deployment, opts)
+	if batchID, ok := job.Labels[BatchIDLabelKey]; ok {
+		newJob.Labels[BatchIDLabelKey] = batchID
+		newJob.Annotations[BatchItemAnnotationKey] = job.Annotations[BatchItemAnnotationKey]
+	}
+	err = createJobExclusively(clientset, deployment, opts, notify, func() error {
+		newJob, err = createJobResource(clientset, newJob, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	recordJobHistory(newJob, getContextName())
+	return newJob, nil
+}
+
+// cancelJob stops a job's pods while keeping the job around, by shortening its deadline so that
+// the job controller fails it.
+func cancelJob(clientset *kubernetes.Clientset, job *batchv1.Job) error {
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:"true"}},"spec":{"activeDeadlineSeconds":1}}`, CancelledAnnotationKey)
+	_, err := clientset.BatchV1().Jobs(job.Namespace).Patch(context.TODO(), job.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+func deleteJob(clientset *kubernetes.Clientset, job *batchv1.Job) error {
+	propagationPolicy := metav1.DeletePropagationBackground
+	return clientset.BatchV1().Jobs(job.Namespace).Delete(context.TODO(), job.Name, metav1.DeleteOptions{
+		PropagationPolicy: &propagationPolicy,
+	})
 }
 
 func getPrimaryContainer(deployment *appv1.Deployment) int {
@@ -253,6 +529,17 @@ func getPrimaryContainer(deployment *appv1.Deployment) int {
 	}
 }
 
+// getJobPrimaryContainer returns the container of a job that runs the command.
+func getJobPrimaryContainer(job *batchv1.Job) *corev1.Container {
+	containers := job.Spec.Template.Spec.Containers
+	for i := range containers {
+		if containers[i].Name == job.Annotations[PrimaryContainerAnnotationKey] {
+			return &containers[i]
+		}
+	}
+	return &containers[0]
+}
+
 func getPrimaryContainerImageTag(deployment *appv1.Deployment, imageTagOverride string) string {
 	if imageTagOverride != "" {
 		return imageTagOverride
@@ -267,6 +554,19 @@ func getPrimaryContainerImageTag(deployment *appv1.Deployment, imageTagOverride
 	}
 }
 
+func getCompletionIndex(pod *corev1.Pod) string {
+	return pod.Annotations[CompletionIndexAnnotationKey]
+}
+
+func getJobImageTag(job *batchv1.Job) string {
+	for _, c := range job.Spec.Template.Spec.Containers {
+		if c.Name == job.Annotations[PrimaryContainerAnnotationKey] && strings.Contains(c.Image, ":") {
+			return c.Image[strings.Index(c.Image, ":")+1:]
+		}
+	}
+	return ""
+}
+
 func getDeploymentName(deployment *appv1.Deployment) string {
 	if val, ok := deployment.Annotations[DeploymentAliasAnnotationKey]; ok {
 		return val
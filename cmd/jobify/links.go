@@ -0,0 +1,157 @@
+package jobify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	LinkTemplatesAnnotationKey = "jobify/link-templates"
+	LogsLinkName               = "logs"
+)
+
+// getLinkTemplates returns the named link templates of a deployment or a job, e.g. logs, metrics
+// and traces. The log URL template is the logs link, unless the link templates have one.
+func getLinkTemplates(annotations map[string]string) (map[string]string, error) {
+	templates := map[string]string{}
+	if annotation, ok := annotations[LinkTemplatesAnnotationKey]; ok {
+		if err := json.Unmarshal([]byte(annotation), &templates); err != nil {
+			return nil, fmt.Errorf("invalid link templates annotation %s: %s", LinkTemplatesAnnotationKey, err.Error())
+		}
+	}
+	if logURLTemplate, ok := annotations[LogsURLTemplateAnnotationKey]; ok && templates[LogsLinkName] == "" {
+		templates[LogsLinkName] = logURLTemplate
+	}
+	return templates, nil
+}
+
+// getLinkNames returns the names of the links sorted, with the logs first.
+func getLinkNames(templates map[string]string) []string {
+	names := []string{}
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == LogsLinkName || names[j] == LogsLinkName {
+			return names[i] == LogsLinkName
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// getLinkReplacer replaces the placeholders of link templates with the URL-encoded details of the
+// job. The completion time of an unfinished job is the current time, so that time ranges include
+// everything up to now. Longer placeholders come first, since they're matched in order.
+func getLinkReplacer(job *batchv1.Job, podList *corev1.PodList, templates map[string]string) *strings.Replacer {
+	var contextName, clusterName string
+	for _, t := range templates {
+		if strings.Contains(t, "$CONTEXT") || strings.Contains(t, "$CLUSTER") {
+			contextName = getContextName()
+			clusterName = getContextCluster(contextName)
+			break
+		}
+	}
+
+	podNames := []string{}
+	newestPod := ""
+	if podList != nil {
+		var newest time.Time
+		for _, p := range podList.Items {
+			podNames = append(podNames, p.Name)
+			if !p.CreationTimestamp.Time.Before(newest) {
+				newest = p.CreationTimestamp.Time
+				newestPod = p.Name
+			}
+		}
+	}
+
+	created := job.CreationTimestamp.Time
+	completed := getJobCompletionTime(job)
+	return strings.NewReplacer(
+		"$JOB", url.QueryEscape(job.Name),
+		"$NAMESPACE", url.QueryEscape(job.Namespace),
+		"$CONTAINER", url.QueryEscape(job.Annotations[PrimaryContainerAnnotationKey]),
+		"$CONTEXT", url.QueryEscape(contextName),
+		"$CLUSTER", url.QueryEscape(clusterName),
+		"$DEPLOYMENT", url.QueryEscape(job.Annotations[SourceDeploymentAnnotationKey]),
+		"$PODS", url.QueryEscape(strings.Join(podNames, ",")),
+		"$POD", url.QueryEscape(newestPod),
+		"$CREATED_MS", strconv.FormatInt(created.UnixNano()/int64(time.Millisecond), 10),
+		"$CREATED", url.QueryEscape(created.UTC().Format(time.RFC3339)),
+		"$COMPLETED_MS", strconv.FormatInt(completed.UnixNano()/int64(time.Millisecond), 10),
+		"$COMPLETED", url.QueryEscape(completed.UTC().Format(time.RFC3339)),
+	)
+}
+
+// getJobCompletionTime returns when the job completed or failed, or the current time if it's still
+// running.
+func getJobCompletionTime(job *batchv1.Job) time.Time {
+	if job.Status.CompletionTime != nil {
+		return job.Status.CompletionTime.Time
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return c.LastTransitionTime.Time
+		}
+	}
+	return time.Now()
+}
+
+func getContextCluster(contextName string) string {
+	rawConfig, err := getClientConfigForContext(contextName).RawConfig()
+	if err != nil {
+		return ""
+	}
+	if c, ok := rawConfig.Contexts[contextName]; ok {
+		return c.Cluster
+	}
+	return ""
+}
+
+func openJobLink(clientset *kubernetes.Clientset, job *batchv1.Job, linkName string) {
+	templates, err := getLinkTemplates(job.Annotations)
+	if err != nil {
+		fmt.Printf("Error reading the job's links: %s\n", err.Error())
+		os.Exit(1)
+	}
+	template, ok := templates[linkName]
+	if !ok {
+		if len(templates) == 0 {
+			fmt.Println("The job has no links, its deployment needs a " + LogsURLTemplateAnnotationKey + " or " + LinkTemplatesAnnotationKey + " annotation")
+		} else {
+			fmt.Printf("The job has no %s link, its links are: %s\n", linkName, strings.Join(getLinkNames(templates), ", "))
+		}
+		os.Exit(1)
+	}
+
+	link := getLinkReplacer(job, getJobPods(clientset, job), templates).Replace(template)
+	fmt.Printf("Opening %s\n", link)
+	if err := openBrowser(link); err != nil {
+		fmt.Printf("Error opening the browser: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func openBrowser(link string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", link).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", link).Start()
+	default:
+		return exec.Command("xdg-open", link).Start()
+	}
+}
@@ -0,0 +1,180 @@
+package jobify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	NotifyBell         = "bell"
+	NotifyDesktop      = "desktop"
+	NotifyWebhook      = "webhook"
+	NotifySlack        = "slack"
+	notifyPollInterval = 5 * time.Second
+	notifyTimeout      = 10 * time.Second
+)
+
+// NotifyTarget is where to send a notification once a job finishes, given as bell, desktop,
+// webhook=URL or slack=URL.
+type NotifyTarget struct {
+	Kind string
+	URL  string
+}
+
+// JobNotification is the payload of webhook notifications.
+type JobNotification struct {
+	Job             string            `json:"job"`
+	Namespace       string            `json:"namespace"`
+	Context         string            `json:"context,omitempty"`
+	Deployment      string            `json:"deployment"`
+	State           string            `json:"state"`
+	Succeeded       bool              `json:"succeeded"`
+	CreatedBy       string            `json:"createdBy,omitempty"`
+	Reason          string            `json:"reason,omitempty"`
+	StartedAt       time.Time         `json:"startedAt"`
+	FinishedAt      time.Time         `json:"finishedAt"`
+	Duration        string            `json:"duration"`
+	DurationSeconds int64             `json:"durationSeconds"`
+	LogURL          string            `json:"logURL,omitempty"`
+	Links           map[string]string `json:"links,omitempty"`
+}
+
+func parseNotifyTargets(values []string) ([]NotifyTarget, error) {
+	targets := []NotifyTarget{}
+	for _, value := range values {
+		kind, target := value, ""
+		if i := strings.Index(value, "="); i != -1 {
+			kind, target = value[:i], value[i+1:]
+		}
+		switch kind {
+		case NotifyBell, NotifyDesktop:
+			if target != "" {
+				return nil, fmt.Errorf("%s doesn't take a URL", kind)
+			}
+		case NotifyWebhook, NotifySlack:
+			if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+				return nil, fmt.Errorf("%s needs an HTTP URL, e.g. %s=https://example.com/hook", kind, kind)
+			}
+		default:
+			return nil, fmt.Errorf("unknown notification %s, it must be %s, %s, %s=URL or %s=URL", value, NotifyBell, NotifyDesktop, NotifyWebhook, NotifySlack)
+		}
+		targets = append(targets, NotifyTarget{Kind: kind, URL: target})
+	}
+	return targets, nil
+}
+
+func getJobNotification(job *batchv1.Job) *JobNotification {
+	state, _ := getJobState(job)
+	completed, _ := checkJobCondition(job)
+	started := job.CreationTimestamp.Time
+	if job.Status.StartTime != nil {
+		started = job.Status.StartTime.Time
+	}
+	finished := getJobCompletionTime(job)
+	n := &JobNotification{
+		Job:             job.Name,
+		Namespace:       job.Namespace,
+		Context:         getContextName(),
+		Deployment:      job.Annotations[SourceDeploymentAnnotationKey],
+		State:           state,
+		Succeeded:       completed,
+		CreatedBy:       job.Annotations[CreatedByAnnotationKey],
+		Reason:          job.Annotations[ReasonAnnotationKey],
+		StartedAt:       started,
+		FinishedAt:      finished,
+		Duration:        duration.HumanDuration(finished.Sub(started)),
+		DurationSeconds: int64(finished.Sub(started).Seconds()),
+	}
+	if templates, err := getLinkTemplates(job.Annotations); err == nil && len(templates) > 0 {
+		replacer := getLinkReplacer(job, nil, templates)
+		n.Links = map[string]string{}
+		for name, template := range templates {
+			n.Links[name] = replacer.Replace(template)
+		}
+		n.LogURL = n.Links[LogsLinkName]
+	}
+	return n
+}
+
+func (n *JobNotification) summary() string {
+	return fmt.Sprintf("Job %s/%s: %s after %s", n.Namespace, n.Job, strings.ToLower(n.State), n.Duration)
+}
+
+// notifyJobFinished sends every notification, failing ones are only reported so that the others
+// are still sent.
+func notifyJobFinished(job *batchv1.Job, targets []NotifyTarget) {
+	n := getJobNotification(job)
+	for _, t := range targets {
+		var err error
+		switch t.Kind {
+		case NotifyBell:
+			fmt.Print("\a")
+		case NotifyDesktop:
+			err = exec.Command("notify-send", "jobify", n.summary()).Run()
+		case NotifyWebhook:
+			err = postNotification(t.URL, n)
+		case NotifySlack:
+			err = postNotification(t.URL, getSlackMessage(n))
+		}
+		if err != nil {
+			yellow.Printf("Warning: couldn't send the %s notification: %s\n", t.Kind, err.Error())
+		}
+	}
+}
+
+// getSlackMessage returns a payload that Slack incoming webhooks, and the chat tools compatible with
+// them, accept.
+func getSlackMessage(n *JobNotification) map[string]string {
+	text := n.summary()
+	if n.Reason != "" {
+		text += "\nReason: " + n.Reason
+	}
+	if n.LogURL != "" {
+		text += fmt.Sprintf("\n<%s|View logs>", n.LogURL)
+	}
+	return map[string]string{"text": text}
+}
+
+func postNotification(url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("the webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// waitAndNotify waits for a job to finish and sends the notifications. It exits with an error when
+// the job didn't complete, so that scripts can wait on it.
+func waitAndNotify(clientset *kubernetes.Clientset, job *batchv1.Job, targets []NotifyTarget) {
+	fmt.Printf("Waiting for job %s/%s to finish...\n", job.Namespace, job.Name)
+	finished, err := waitForJobFinished(clientset, job, notifyPollInterval, 0)
+	if err != nil {
+		fmt.Printf("Error waiting for the job: %s\n", err.Error())
+		os.Exit(1)
+	}
+	state, icon := getJobState(finished)
+	printAttribute("State", state+" "+icon)
+	notifyJobFinished(finished, targets)
+	if completed, _ := checkJobCondition(finished); !completed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,419 @@
+package jobify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	PipelineRunLabelKey        = "jobify/pipeline-run"
+	PipelineAnnotationKey      = "jobify/pipeline"
+	PipelineStepAnnotationKey  = "jobify/pipeline-step"
+	PipelineStepsAnnotationKey = "jobify/pipeline-steps"
+	FailurePolicyHalt          = "halt"
+	FailurePolicyContinue      = "continue"
+	pipelinePollInterval       = 5 * time.Second
+	pipelineStepStateSucceeded = "succeeded"
+	pipelineStepStateFailed    = "failed"
+	pipelineStepStateSkipped   = "skipped"
+)
+
+// Pipeline is a sequence of jobify jobs, possibly from different deployments, where each step
+// starts once the steps it depends on have completed.
+type Pipeline struct {
+	Name  string          `json:"name"`
+	Steps []*PipelineStep `json:"steps"`
+}
+
+type PipelineStep struct {
+	Name string `json:"name"`
+	// Deployment is "namespace/name", the name can also be the deployment's alias
+	Deployment string   `json:"deployment"`
+	Command    string   `json:"command"`
+	ImageTag   string   `json:"imageTag,omitempty"`
+	Deadline   string   `json:"deadline,omitempty"`
+	Reason     string   `json:"reason,omitempty"`
+	DependsOn  []string `json:"dependsOn,omitempty"`
+	// OnFailure is either "halt", the default, which stops the pipeline, or "continue", which only
+	// skips the steps that depend on the failed one
+	OnFailure string `json:"onFailure,omitempty"`
+}
+
+func readPipeline(path string) (*Pipeline, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pipeline := &Pipeline{}
+	if err := yaml.UnmarshalStrict(data, pipeline); err != nil {
+		return nil, fmt.Errorf("invalid pipeline file %s: %s", path, err.Error())
+	}
+	if err := validatePipeline(pipeline); err != nil {
+		return nil, fmt.Errorf("invalid pipeline file %s: %s", path, err.Error())
+	}
+	return pipeline, nil
+}
+
+func validatePipeline(pipeline *Pipeline) error {
+	if pipeline.Name == "" {
+		return errors.New("the pipeline must have a name")
+	}
+	if len(pipeline.Steps) == 0 {
+		return errors.New("the pipeline must have at least one step")
+	}
+	steps := map[string]*PipelineStep{}
+	for _, step := range pipeline.Steps {
+		if step.Name == "" || step.Deployment == "" || step.Command == "" {
+			return errors.New("every step needs a name, a deployment and a command")
+		}
+		if _, ok := steps[step.Name]; ok {
+			return fmt.Errorf("step %s is defined twice", step.Name)
+		}
+		if !strings.Contains(step.Deployment, "/") {
+			return fmt.Errorf("the deployment of step %s must be in the format namespace/name", step.Name)
+		}
+		if step.OnFailure == "" {
+			step.OnFailure = FailurePolicyHalt
+		} else if step.OnFailure != FailurePolicyHalt && step.OnFailure != FailurePolicyContinue {
+			return fmt.Errorf("the failure policy of step %s must be %s or %s", step.Name, FailurePolicyHalt, FailurePolicyContinue)
+		}
+		if step.Deadline != "" {
+			if _, err := time.ParseDuration(step.Deadline); err != nil {
+				return fmt.Errorf("invalid deadline for step %s: %s", step.Name, err.Error())
+			}
+		}
+		steps[step.Name] = step
+	}
+	for _, step := range pipeline.Steps {
+		for _, dependency := range step.DependsOn {
+			if _, ok := steps[dependency]; !ok {
+				return fmt.Errorf("step %s depends on unknown step %s", step.Name, dependency)
+			}
+		}
+	}
+	_, err := sortPipelineSteps(pipeline)
+	return err
+}
+
+// sortPipelineSteps orders the steps so that every step comes after its dependencies, keeping the
+// order of the file where possible.
+func sortPipelineSteps(pipeline *Pipeline) ([]*PipelineStep, error) {
+	sorted := []*PipelineStep{}
+	added := map[string]bool{}
+	for len(sorted) < len(pipeline.Steps) {
+		progress := false
+		for _, step := range pipeline.Steps {
+			if added[step.Name] {
+				continue
+			}
+			ready := true
+			for _, dependency := range step.DependsOn {
+				ready = ready && added[dependency]
+			}
+			if ready {
+				sorted = append(sorted, step)
+				added[step.Name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			return nil, errors.New("the steps' dependencies form a cycle")
+		}
+	}
+	return sorted, nil
+}
+
+func runPipeline(clientset *kubernetes.Clientset, pipeline *Pipeline, force bool) {
+	steps, _ := sortPipelineSteps(pipeline)
+	runID := randomString(8)
+	fmt.Printf("Running pipeline %s as run %s\n", pipeline.Name, runID)
+	stepJobs, ok := checkPipelineSteps(clientset, pipeline, steps, runID, force)
+	if !ok {
+		fmt.Println("No jobs were created, fix the steps and run the pipeline again")
+		os.Exit(1)
+	}
+
+	states := map[string]string{}
+	halted := false
+	for _, step := range steps {
+		fmt.Println()
+		if halted {
+			states[step.Name] = pipelineStepStateSkipped
+			faint.Printf("Skipping step %s, the pipeline was halted\n", step.Name)
+			continue
+		}
+		failedDependency := ""
+		for _, dependency := range step.DependsOn {
+			if states[dependency] != pipelineStepStateSucceeded {
+				failedDependency = dependency
+			}
+		}
+		if failedDependency != "" {
+			states[step.Name] = pipelineStepStateSkipped
+			faint.Printf("Skipping step %s, it depends on step %s which didn't succeed\n", step.Name, failedDependency)
+			continue
+		}
+
+		cyan.Printf("Step %s: ", step.Name)
+		fmt.Printf("%s on %s\n", step.Command, step.Deployment)
+		job, err := createPipelineStepJob(clientset, pipeline, step, stepJobs[step.Name], runID)
+		if err == nil {
+			err = waitForJob(clientset, job, getStepDeadline(step))
+		}
+		if err != nil {
+			states[step.Name] = pipelineStepStateFailed
+			printConflicts(err)
+			red.Printf("Step %s failed: %s\n", step.Name, err.Error())
+			if step.OnFailure == FailurePolicyHalt {
+				halted = true
+			}
+			continue
+		}
+		states[step.Name] = pipelineStepStateSucceeded
+		fmt.Printf("Step %s completed %s\n", step.Name, emoji("✅"))
+	}
+
+	fmt.Println()
+	printAttribute("Pipeline", pipeline.Name)
+	printAttribute("Run", runID)
+	printAttribute("Steps", "")
+	for _, step := range steps {
+		fprintAttributeWithIndentation(color.Output, step.Name, states[step.Name], 1)
+	}
+	for _, state := range states {
+		if state != pipelineStepStateSucceeded {
+			os.Exit(1)
+		}
+	}
+}
+
+// pipelineStepJob is the deployment and job options of a step, checked before the pipeline starts.
+type pipelineStepJob struct {
+	deployment *appv1.Deployment
+	opts       *JobOptions
+}
+
+// checkPipelineSteps runs the same checks as a new job on every step before the first job is
+// created, so that a pipeline doesn't stop halfway because of a step that can't be created. The
+// problems of every step are printed.
+func checkPipelineSteps(clientset *kubernetes.Clientset, pipeline *Pipeline, steps []*PipelineStep, runID string, force bool) (map[string]*pipelineStepJob, bool) {
+	stepJobs := map[string]*pipelineStepJob{}
+	ok := true
+	for _, step := range steps {
+		stepJob, problems, err := getPipelineStepJob(clientset, pipeline, step, runID, force)
+		if err != nil {
+			problems = []string{err.Error()}
+		}
+		if len(problems) > 0 {
+			red.Printf("Step %s can't be created:\n", step.Name)
+			for _, p := range problems {
+				fmt.Print("  - ")
+				red.Println(p)
+			}
+			ok = false
+			continue
+		}
+		stepJobs[step.Name] = stepJob
+	}
+	return stepJobs, ok
+}
+
+func getPipelineStepJob(clientset *kubernetes.Clientset, pipeline *Pipeline, step *PipelineStep, runID string, force bool) (*pipelineStepJob, []string, error) {
+	namespace, name := parseDeploymentArg(step.Deployment)
+	deployment, err := findDeployment(clientset, namespace, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := validateDeployment(deployment); err != nil {
+		return nil, nil, err
+	}
+
+	opts := &JobOptions{
+		Command:          step.Command,
+		ImageTagOverride: step.ImageTag,
+		Reason:           step.Reason,
+		CreatedBy:        getCurrentUser(clientset),
+		Force:            force,
+	}
+	if opts.Reason == "" {
+		opts.Reason = fmt.Sprintf("Pipeline %s, run %s", pipeline.Name, runID)
+	}
+	if step.Deadline != "" {
+		deadline, _ := time.ParseDuration(step.Deadline)
+		opts.DeadlineSeconds = int64(deadline.Seconds())
+	}
+	checks, err := loadJobChecks(clientset, deployment)
+	if err != nil {
+		return nil, nil, err
+	}
+	problems, _ := checks(opts)
+	return &pipelineStepJob{deployment: deployment, opts: opts}, problems, nil
+}
+
+func createPipelineStepJob(clientset *kubernetes.Clientset, pipeline *Pipeline, step *PipelineStep, stepJob *pipelineStepJob, runID string) (*batchv1.Job, error) {
+	deployment, opts := stepJob.deployment, stepJob.opts
+	job := setupJob(deployment, opts)
+	job.Labels[PipelineRunLabelKey] = runID
+	job.Annotations[PipelineAnnotationKey] = pipeline.Name
+	job.Annotations[PipelineStepAnnotationKey] = step.Name
+	// Every step is recorded so that the status of a run can list the steps that never got a job
+	steps, _ := sortPipelineSteps(pipeline)
+	stepNames := []string{}
+	for _, s := range steps {
+		stepNames = append(stepNames, s.Name)
+	}
+	stepNamesJSON, _ := json.Marshal(stepNames)
+	job.Annotations[PipelineStepsAnnotationKey] = string(stepNamesJSON)
+	err := createJobExclusively(clientset, deployment, opts, printNotice, func() error {
+		_, err := createJobResource(clientset, job, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	recordJobHistory(job, getContextName())
+	fmt.Printf("Created job %s/%s\n", job.Namespace, job.Name)
+	if getApprovalState(job) == ApprovalPending {
+		yellow.Printf("The job requires approval, waiting for someone to run: jobify approve %s %s\n", job.Namespace, job.Name)
+	}
+	return job, nil
+}
+
+// findDeployment gets a deployment by name, falling back to the jobify deployment of the
+// namespace with that alias.
+func findDeployment(clientset *kubernetes.Clientset, namespace, name string) (*appv1.Deployment, error) {
+	deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err == nil {
+		return deployment, nil
+	}
+	deployments, listErr := clientset.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "jobify=true",
+	})
+	if listErr != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		if getDeploymentName(&deployments.Items[i]) == name {
+			return &deployments.Items[i], nil
+		}
+	}
+	return nil, err
+}
+
+// getStepDeadline returns how long a step has to finish, including the time it waits for approval.
+func getStepDeadline(step *PipelineStep) time.Duration {
+	if step.Deadline != "" {
+		deadline, _ := time.ParseDuration(step.Deadline)
+		return deadline
+	}
+	return time.Duration(DefaultDeadlineSeconds) * time.Second
+}
+
+// waitForJob polls a job until it completes or fails, returning an error when it fails or doesn't
+// finish within the timeout.
+func waitForJob(clientset *kubernetes.Clientset, job *batchv1.Job, timeout time.Duration) error {
+	current, err := waitForJobFinished(clientset, job, pipelinePollInterval, timeout)
+	if err == errJobWaitTimeout {
+		return fmt.Errorf("job %s/%s didn't finish within %s, view it with: jobify view %s %s", job.Namespace, job.Name, timeout, job.Namespace, job.Name)
+	} else if err != nil {
+		return err
+	}
+	completed, failed := checkJobCondition(current)
+	if completed {
+		return nil
+	} else if failed {
+		return fmt.Errorf("job %s/%s failed, view it with: jobify view %s %s", job.Namespace, job.Name, job.Namespace, job.Name)
+	}
+	return fmt.Errorf("job %s/%s was rejected by %s", job.Namespace, job.Name, current.Annotations[RejectedByAnnotationKey])
+}
+
+var errJobWaitTimeout = errors.New("timed out waiting for the job to finish")
+
+// waitForJobFinished polls a job until it completes, fails or is rejected, and returns it. A zero
+// timeout waits forever.
+func waitForJobFinished(clientset *kubernetes.Clientset, job *batchv1.Job, interval, timeout time.Duration) (*batchv1.Job, error) {
+	start := time.Now()
+	for {
+		current, err := clientset.BatchV1().Jobs(job.Namespace).Get(context.TODO(), job.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		completed, failed := checkJobCondition(current)
+		if completed || failed || getApprovalState(current) == ApprovalRejected {
+			return current, nil
+		}
+		if timeout > 0 && time.Since(start) > timeout {
+			return nil, errJobWaitTimeout
+		}
+		time.Sleep(interval)
+	}
+}
+
+func pipelineStatus(clientset *kubernetes.Clientset, runID string) {
+	jobs, err := clientset.BatchV1().Jobs("").List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("jobify=true,%s=%s", PipelineRunLabelKey, runID),
+	})
+	if err != nil {
+		fmt.Printf("Error listing jobs: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if len(jobs.Items) == 0 {
+		fmt.Printf("No jobs found for pipeline run %s\n", runID)
+		os.Exit(1)
+	}
+	sort.Slice(jobs.Items, func(i, j int) bool {
+		return jobs.Items[i].CreationTimestamp.Before(&jobs.Items[j].CreationTimestamp)
+	})
+
+	printAttribute("Pipeline", jobs.Items[0].Annotations[PipelineAnnotationKey])
+	printAttribute("Run", runID)
+	printAttribute("Steps", "")
+	stepJobs := map[string]*batchv1.Job{}
+	running := false
+	for i := range jobs.Items {
+		j := &jobs.Items[i]
+		stepJobs[j.Annotations[PipelineStepAnnotationKey]] = j
+		running = running || isJobActive(j)
+	}
+	for _, name := range getPipelineStepNames(jobs.Items) {
+		j, ok := stepJobs[name]
+		if !ok && running {
+			fprintAttributeWithIndentation(color.Output, name, "Not started", 1)
+			continue
+		} else if !ok {
+			fprintAttributeWithIndentation(color.Output, name, "Skipped", 1)
+			continue
+		}
+		state, icon := getJobState(j)
+		fprintAttributeWithIndentation(color.Output, name, fmt.Sprintf("%s %s, Job: %s/%s, Duration: %s", state, icon, j.Namespace, j.Name, getJobElapsedTime(j)), 1)
+	}
+}
+
+// getPipelineStepNames returns every step of a run from the latest job, falling back to the steps
+// that have a job for runs that didn't record them.
+func getPipelineStepNames(jobs []batchv1.Job) []string {
+	names := []string{}
+	latest := jobs[len(jobs)-1]
+	if err := json.Unmarshal([]byte(latest.Annotations[PipelineStepsAnnotationKey]), &names); err == nil && len(names) > 0 {
+		return names
+	}
+	names = []string{}
+	for _, j := range jobs {
+		names = append(names, j.Annotations[PipelineStepAnnotationKey])
+	}
+	return names
+}
@@ -0,0 +1,198 @@
+package jobify
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	JobNodeSelectorAnnotationKey    = "jobify/job-node-selector"
+	JobTolerationsAnnotationKey     = "jobify/job-tolerations"
+	JobPriorityClassAnnotationKey   = "jobify/job-priority-class"
+	StripAntiAffinityAnnotationKey  = "jobify/strip-anti-affinity"
+	PlacementOverridesAnnotationKey = "jobify/placement"
+	tolerationEffectsDescription    = "NoSchedule, PreferNoSchedule or NoExecute"
+)
+
+// Placement decides which nodes a job's pods can run on. Deployments set the defaults through
+// annotations, and the options of a job are added on top of them.
+type Placement struct {
+	NodeSelector      map[string]string   `json:"nodeSelector,omitempty"`
+	Tolerations       []corev1.Toleration `json:"tolerations,omitempty"`
+	PriorityClass     string              `json:"priorityClass,omitempty"`
+	StripAntiAffinity bool                `json:"stripAntiAffinity,omitempty"`
+}
+
+func (p *Placement) isEmpty() bool {
+	return len(p.NodeSelector) == 0 && len(p.Tolerations) == 0 && p.PriorityClass == "" && !p.StripAntiAffinity
+}
+
+// parseNodeSelector parses "key=value" pairs separated by commas.
+func parseNodeSelector(value string) (map[string]string, error) {
+	selector := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid node selector %q, it must be in the format key=value", pair)
+		}
+		selector[parts[0]] = parts[1]
+	}
+	return selector, nil
+}
+
+// parseToleration parses a toleration in the format of kubectl taint: "key=value:Effect". Without a
+// value any value of the key is tolerated, and without an effect every effect is.
+func parseToleration(value string) (corev1.Toleration, error) {
+	toleration := corev1.Toleration{Operator: corev1.TolerationOpExists}
+	if colonIndex := strings.LastIndex(value, ":"); colonIndex != -1 {
+		toleration.Effect = corev1.TaintEffect(value[colonIndex+1:])
+		value = value[:colonIndex]
+		switch toleration.Effect {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return toleration, fmt.Errorf("invalid toleration effect %q, it must be %s", toleration.Effect, tolerationEffectsDescription)
+		}
+	}
+	if equalsIndex := strings.Index(value, "="); equalsIndex != -1 {
+		toleration.Operator = corev1.TolerationOpEqual
+		toleration.Value = value[equalsIndex+1:]
+		value = value[:equalsIndex]
+	}
+	if value == "" {
+		return toleration, fmt.Errorf("invalid toleration, it must be in the format key[=value][:effect]")
+	}
+	toleration.Key = value
+	return toleration, nil
+}
+
+func parseTolerations(values []string) ([]corev1.Toleration, error) {
+	tolerations := []corev1.Toleration{}
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		t, err := parseToleration(v)
+		if err != nil {
+			return nil, err
+		}
+		tolerations = append(tolerations, t)
+	}
+	return tolerations, nil
+}
+
+// getDeploymentPlacement reads the placement defaults of a deployment's jobs. Tolerations in the
+// annotation are separated by commas.
+func getDeploymentPlacement(deployment *appv1.Deployment) (*Placement, error) {
+	placement := &Placement{
+		PriorityClass:     deployment.Annotations[JobPriorityClassAnnotationKey],
+		StripAntiAffinity: deployment.Annotations[StripAntiAffinityAnnotationKey] == "true",
+	}
+	var err error
+	if placement.NodeSelector, err = parseNodeSelector(deployment.Annotations[JobNodeSelectorAnnotationKey]); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: %s", JobNodeSelectorAnnotationKey, err.Error())
+	}
+	if placement.Tolerations, err = parseTolerations(strings.Split(deployment.Annotations[JobTolerationsAnnotationKey], ",")); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: %s", JobTolerationsAnnotationKey, err.Error())
+	}
+	return placement, nil
+}
+
+// getJobPlacement combines the deployment's defaults with the job's options, the options winning
+// for node selector keys and the priority class.
+func getJobPlacement(deployment *appv1.Deployment, opts *JobOptions) *Placement {
+	// The annotations were checked by validateDeployment
+	placement, err := getDeploymentPlacement(deployment)
+	if err != nil {
+		placement = &Placement{NodeSelector: map[string]string{}}
+	}
+	if opts.Placement == nil {
+		return placement
+	}
+	for k, v := range opts.Placement.NodeSelector {
+		placement.NodeSelector[k] = v
+	}
+	placement.Tolerations = append(placement.Tolerations, opts.Placement.Tolerations...)
+	if opts.Placement.PriorityClass != "" {
+		placement.PriorityClass = opts.Placement.PriorityClass
+	}
+	placement.StripAntiAffinity = placement.StripAntiAffinity || opts.Placement.StripAntiAffinity
+	return placement
+}
+
+// setupJobPlacement applies the placement to a job. A node selector replaces the deployment's own,
+// since mixing the two usually matches no node at all.
+func setupJobPlacement(job *batchv1.Job, deployment *appv1.Deployment, opts *JobOptions) {
+	placement := getJobPlacement(deployment, opts)
+	podSpec := &job.Spec.Template.Spec
+	if len(placement.NodeSelector) > 0 {
+		podSpec.NodeSelector = placement.NodeSelector
+	}
+	podSpec.Tolerations = append(podSpec.Tolerations, placement.Tolerations...)
+	if placement.PriorityClass != "" {
+		podSpec.PriorityClassName = placement.PriorityClass
+		// The deployment's priority value would conflict with the class's
+		podSpec.Priority = nil
+	}
+	if placement.StripAntiAffinity && podSpec.Affinity != nil {
+		podSpec.Affinity.PodAntiAffinity = nil
+	}
+
+	// Only the options are recorded, reruns get the deployment's defaults again
+	if opts.Placement != nil && !opts.Placement.isEmpty() {
+		data, _ := json.Marshal(opts.Placement)
+		job.Annotations[PlacementOverridesAnnotationKey] = string(data)
+	}
+}
+
+func getJobPlacementOverrides(job *batchv1.Job, opts *JobOptions) {
+	if data, ok := job.Annotations[PlacementOverridesAnnotationKey]; ok {
+		placement := &Placement{}
+		if json.Unmarshal([]byte(data), placement) == nil {
+			opts.Placement = placement
+		}
+	}
+}
+
+func printPlacement(placement *Placement) {
+	if len(placement.NodeSelector) > 0 {
+		selector := []string{}
+		for k, v := range placement.NodeSelector {
+			selector = append(selector, k+"="+v)
+		}
+		sort.Strings(selector)
+		printAttribute("Node Selector", strings.Join(selector, ", "))
+	}
+	if len(placement.Tolerations) > 0 {
+		tolerations := []string{}
+		for _, t := range placement.Tolerations {
+			tolerations = append(tolerations, formatToleration(t))
+		}
+		printAttribute("Tolerations", strings.Join(tolerations, ", "))
+	}
+	if placement.PriorityClass != "" {
+		printAttribute("Priority Class", placement.PriorityClass)
+	}
+	if placement.StripAntiAffinity {
+		printAttribute("Pod Anti-Affinity", "Removed")
+	}
+}
+
+func formatToleration(t corev1.Toleration) string {
+	s := t.Key
+	if t.Operator == corev1.TolerationOpEqual {
+		s += "=" + t.Value
+	}
+	if t.Effect != "" {
+		s += ":" + string(t.Effect)
+	}
+	return s
+}
@@ -0,0 +1,272 @@
+package jobify
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	DropInitContainersAnnotationKey = "jobify/drop-init-containers"
+	KeepInitContainersAnnotationKey = "jobify/keep-init-containers"
+	JobVolumesAnnotationKey         = "jobify/job-volumes"
+	JobServiceAccountAnnotationKey  = "jobify/job-service-account"
+	PodOverridesAnnotationKey       = "jobify/pod-overrides"
+	AllInitContainers               = "*"
+	VolumeTypeEmptyDir              = "emptyDir"
+	VolumeTypePVC                   = "pvc"
+)
+
+// PodOverrides changes what a job inherits from the deployment's pod template. Deployments set the
+// defaults through annotations, and the options of a job are added on top of them.
+type PodOverrides struct {
+	DropInitContainers []string      `json:"dropInitContainers,omitempty"`
+	KeepInitContainers []string      `json:"keepInitContainers,omitempty"`
+	Volumes            []ExtraVolume `json:"volumes,omitempty"`
+	ServiceAccount     string        `json:"serviceAccount,omitempty"`
+}
+
+func (o *PodOverrides) isEmpty() bool {
+	return len(o.DropInitContainers) == 0 && len(o.KeepInitContainers) == 0 && len(o.Volumes) == 0 && o.ServiceAccount == ""
+}
+
+// ExtraVolume is a volume mounted into the primary container, either a scratch emptyDir or an
+// existing PersistentVolumeClaim.
+type ExtraVolume struct {
+	Type      string `json:"type"`
+	ClaimName string `json:"claimName,omitempty"`
+	MountPath string `json:"mountPath"`
+	SizeLimit string `json:"sizeLimit,omitempty"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+// parseExtraVolume parses "emptyDir:/mount/path[:size-limit]" or "pvc:claim-name:/mount/path[:ro]".
+func parseExtraVolume(value string) (ExtraVolume, error) {
+	parts := strings.Split(value, ":")
+	switch {
+	case parts[0] == VolumeTypeEmptyDir && (len(parts) == 2 || len(parts) == 3):
+		v := ExtraVolume{Type: VolumeTypeEmptyDir, MountPath: parts[1]}
+		if len(parts) == 3 {
+			if _, err := resource.ParseQuantity(parts[2]); err != nil {
+				return v, fmt.Errorf("invalid size limit %q in volume %s", parts[2], value)
+			}
+			v.SizeLimit = parts[2]
+		}
+		return v, checkMountPath(v, value)
+	case parts[0] == VolumeTypePVC && (len(parts) == 3 || len(parts) == 4 && parts[3] == "ro"):
+		v := ExtraVolume{Type: VolumeTypePVC, ClaimName: parts[1], MountPath: parts[2], ReadOnly: len(parts) == 4}
+		if v.ClaimName == "" {
+			return v, fmt.Errorf("missing claim name in volume %s", value)
+		}
+		return v, checkMountPath(v, value)
+	}
+	return ExtraVolume{}, fmt.Errorf("invalid volume %s, it must be in the format %s:/mount/path[:size-limit] or %s:claim-name:/mount/path[:ro]", value, VolumeTypeEmptyDir, VolumeTypePVC)
+}
+
+func checkMountPath(v ExtraVolume, value string) error {
+	if !strings.HasPrefix(v.MountPath, "/") {
+		return fmt.Errorf("the mount path of volume %s must be absolute", value)
+	}
+	return nil
+}
+
+func parseExtraVolumes(values []string) ([]ExtraVolume, error) {
+	volumes := []ExtraVolume{}
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		v, err := parseExtraVolume(value)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, v)
+	}
+	return volumes, nil
+}
+
+func (v ExtraVolume) String() string {
+	if v.Type == VolumeTypePVC {
+		s := fmt.Sprintf("PVC %s at %s", v.ClaimName, v.MountPath)
+		if v.ReadOnly {
+			s += " (read-only)"
+		}
+		return s
+	}
+	s := "Scratch emptyDir at " + v.MountPath
+	if v.SizeLimit != "" {
+		s += " (limit " + v.SizeLimit + ")"
+	}
+	return s
+}
+
+func splitAnnotationList(value string) []string {
+	list := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// getDeploymentPodOverrides reads the defaults of a deployment's jobs. The lists in the annotations
+// are separated by commas.
+func getDeploymentPodOverrides(deployment *appv1.Deployment) (*PodOverrides, error) {
+	overrides := &PodOverrides{
+		DropInitContainers: splitAnnotationList(deployment.Annotations[DropInitContainersAnnotationKey]),
+		KeepInitContainers: splitAnnotationList(deployment.Annotations[KeepInitContainersAnnotationKey]),
+		ServiceAccount:     deployment.Annotations[JobServiceAccountAnnotationKey],
+	}
+	var err error
+	if overrides.Volumes, err = parseExtraVolumes(splitAnnotationList(deployment.Annotations[JobVolumesAnnotationKey])); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: %s", JobVolumesAnnotationKey, err.Error())
+	}
+	return overrides, nil
+}
+
+// getEffectivePodOverrides combines the deployment's defaults with the job's options. The job's list
+// of init containers to keep replaces the deployment's, the other lists are combined.
+func getEffectivePodOverrides(deployment *appv1.Deployment, opts *JobOptions) *PodOverrides {
+	// The annotations were checked by validateDeployment
+	overrides, err := getDeploymentPodOverrides(deployment)
+	if err != nil {
+		overrides = &PodOverrides{}
+	}
+	if opts.PodOverrides == nil {
+		return overrides
+	}
+	overrides.DropInitContainers = append(overrides.DropInitContainers, opts.PodOverrides.DropInitContainers...)
+	if len(opts.PodOverrides.KeepInitContainers) > 0 {
+		overrides.KeepInitContainers = opts.PodOverrides.KeepInitContainers
+	}
+	overrides.Volumes = append(overrides.Volumes, opts.PodOverrides.Volumes...)
+	if opts.PodOverrides.ServiceAccount != "" {
+		overrides.ServiceAccount = opts.PodOverrides.ServiceAccount
+	}
+	return overrides
+}
+
+// isInitContainerKept tells whether a job keeps an init container of the deployment. When a list of
+// init containers to keep is given, all others are dropped.
+func isInitContainerKept(overrides *PodOverrides, name string) bool {
+	if len(overrides.KeepInitContainers) > 0 && !containsString(overrides.KeepInitContainers, name) {
+		return false
+	}
+	return !containsString(overrides.DropInitContainers, AllInitContainers) && !containsString(overrides.DropInitContainers, name)
+}
+
+func checkPodOverrides(deployment *appv1.Deployment, opts *JobOptions) []string {
+	problems := []string{}
+	overrides := getEffectivePodOverrides(deployment, opts)
+	podSpec := deployment.Spec.Template.Spec
+	for _, name := range append(overrides.DropInitContainers, overrides.KeepInitContainers...) {
+		found := name == AllInitContainers
+		for _, c := range podSpec.InitContainers {
+			found = found || c.Name == name
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("The deployment has no init container %s", name))
+		}
+	}
+	primaryContainer := podSpec.Containers[getPrimaryContainer(deployment)]
+	mountPaths := map[string]bool{}
+	for _, m := range primaryContainer.VolumeMounts {
+		mountPaths[m.MountPath] = true
+	}
+	for _, v := range overrides.Volumes {
+		if mountPaths[v.MountPath] {
+			problems = append(problems, fmt.Sprintf("Container %s already has a volume mounted at %s", primaryContainer.Name, v.MountPath))
+		}
+		mountPaths[v.MountPath] = true
+	}
+	return problems
+}
+
+// setupJobPodOverrides removes the unwanted init containers from a job, mounts the extra volumes
+// into the primary container and overrides the service account.
+func setupJobPodOverrides(job *batchv1.Job, deployment *appv1.Deployment, opts *JobOptions) {
+	overrides := getEffectivePodOverrides(deployment, opts)
+	podSpec := &job.Spec.Template.Spec
+
+	initContainers := []corev1.Container{}
+	for _, c := range podSpec.InitContainers {
+		if isInitContainerKept(overrides, c.Name) {
+			initContainers = append(initContainers, c)
+		}
+	}
+	podSpec.InitContainers = initContainers
+
+	for i, v := range overrides.Volumes {
+		volume := corev1.Volume{Name: fmt.Sprintf("jobify-volume-%d", i)}
+		if v.Type == VolumeTypePVC {
+			volume.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{ClaimName: v.ClaimName, ReadOnly: v.ReadOnly}
+		} else {
+			volume.EmptyDir = &corev1.EmptyDirVolumeSource{}
+			if v.SizeLimit != "" {
+				sizeLimit := resource.MustParse(v.SizeLimit)
+				volume.EmptyDir.SizeLimit = &sizeLimit
+			}
+		}
+		podSpec.Volumes = append(podSpec.Volumes, volume)
+		container := getJobPrimaryContainer(job)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      volume.Name,
+			MountPath: v.MountPath,
+			ReadOnly:  v.ReadOnly,
+		})
+	}
+
+	if overrides.ServiceAccount != "" {
+		podSpec.ServiceAccountName = overrides.ServiceAccount
+		podSpec.DeprecatedServiceAccount = ""
+	}
+
+	// Only the options are recorded, reruns get the deployment's defaults again
+	if opts.PodOverrides != nil && !opts.PodOverrides.isEmpty() {
+		data, _ := json.Marshal(opts.PodOverrides)
+		job.Annotations[PodOverridesAnnotationKey] = string(data)
+	}
+}
+
+func getJobPodOverrides(job *batchv1.Job, opts *JobOptions) {
+	if data, ok := job.Annotations[PodOverridesAnnotationKey]; ok {
+		overrides := &PodOverrides{}
+		if json.Unmarshal([]byte(data), overrides) == nil {
+			opts.PodOverrides = overrides
+		}
+	}
+}
+
+func printPodOverrides(deployment *appv1.Deployment, opts *JobOptions) {
+	overrides := getEffectivePodOverrides(deployment, opts)
+	kept, dropped := []string{}, []string{}
+	for _, c := range deployment.Spec.Template.Spec.InitContainers {
+		if isInitContainerKept(overrides, c.Name) {
+			kept = append(kept, c.Name)
+		} else {
+			dropped = append(dropped, c.Name)
+		}
+	}
+	if len(dropped) > 0 {
+		printAttribute("Init Containers", fmt.Sprintf("Keeping %s, dropping %s", formatList(kept), formatList(dropped)))
+	}
+	for _, v := range overrides.Volumes {
+		printAttribute("Extra Volume", v.String())
+	}
+	if overrides.ServiceAccount != "" {
+		printAttribute("Service Account", overrides.ServiceAccount)
+	}
+}
+
+func formatList(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
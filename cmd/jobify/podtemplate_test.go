@@ -0,0 +1,46 @@
+package jobify
+
+import (
+	"testing"
+)
+
+func TestParseExtraVolume(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    ExtraVolume
+		wantErr bool
+	}{
+		{value: "emptyDir:/scratch", want: ExtraVolume{Type: VolumeTypeEmptyDir, MountPath: "/scratch"}},
+		{value: "emptyDir:/scratch:10Gi", want: ExtraVolume{Type: VolumeTypeEmptyDir, MountPath: "/scratch", SizeLimit: "10Gi"}},
+		{value: "emptyDir:/scratch:lots", wantErr: true},
+		{value: "emptyDir:scratch", wantErr: true},
+		{value: "emptyDir", wantErr: true},
+		{value: "emptyDir:/scratch:10Gi:ro", wantErr: true},
+		{value: "pvc:data:/data", want: ExtraVolume{Type: VolumeTypePVC, ClaimName: "data", MountPath: "/data"}},
+		{value: "pvc:data:/data:ro", want: ExtraVolume{Type: VolumeTypePVC, ClaimName: "data", MountPath: "/data", ReadOnly: true}},
+		{value: "pvc:data:/data:rw", wantErr: true},
+		{value: "pvc::/data", wantErr: true},
+		{value: "pvc:data:data", wantErr: true},
+		{value: "pvc:/data", wantErr: true},
+		{value: "hostPath:/var/run:/run", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseExtraVolume(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseExtraVolume(%q) = %+v, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseExtraVolume(%q) error = %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseExtraVolume(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package jobify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	appv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	PolicyAnnotationKey      = "jobify/policy"
+	PolicyConfigMapNamespace = "kube-system"
+	PolicyConfigMapName      = "jobify-policy"
+	PolicyConfigMapKey       = "policy.json"
+	DefaultDeadlineSeconds   = int64(24 * 60 * 60)
+)
+
+// Policy restricts the jobs that can be created from a deployment. Every list is optional, an
+// empty list doesn't restrict anything.
+type Policy struct {
+	AllowedCommands     []string `json:"allowedCommands,omitempty"`
+	DeniedCommands      []string `json:"deniedCommands,omitempty"`
+	ForbiddenSubstrings []string `json:"forbiddenSubstrings,omitempty"`
+	MaxDeadlineSeconds  int64    `json:"maxDeadlineSeconds,omitempty"`
+	AllowedImageTags    []string `json:"allowedImageTags,omitempty"`
+}
+
+// ClusterPolicy is the content of the cluster-wide policy ConfigMap. The default policy applies to
+// every deployment, and deployment policies are keyed by "namespace/deployment-name".
+type ClusterPolicy struct {
+	Default     *Policy            `json:"default,omitempty"`
+	Deployments map[string]*Policy `json:"deployments,omitempty"`
+}
+
+type sourcedPolicy struct {
+	Source string
+	Policy *Policy
+}
+
+// loadPolicies returns the policies of a deployment. Users who can't read the cluster policy can't
+// create jobs, since skipping it would let them get around it.
+func loadPolicies(clientset *kubernetes.Clientset, deployment *appv1.Deployment) ([]sourcedPolicy, error) {
+	configMap, err := clientset.CoreV1().ConfigMaps(PolicyConfigMapNamespace).Get(context.TODO(), PolicyConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return getDeploymentPolicies(deployment, nil)
+	} else if apierrors.IsForbidden(err) {
+		return nil, fmt.Errorf("not allowed to read the cluster policy %s/%s, ask an admin to grant get on configmaps/%s in %s", PolicyConfigMapNamespace, PolicyConfigMapName, PolicyConfigMapName, PolicyConfigMapNamespace)
+	} else if err != nil {
+		return nil, fmt.Errorf("couldn't read the cluster policy %s/%s: %s", PolicyConfigMapNamespace, PolicyConfigMapName, err.Error())
+	}
+
+	clusterPolicy := &ClusterPolicy{}
+	if err := json.Unmarshal([]byte(configMap.Data[PolicyConfigMapKey]), clusterPolicy); err != nil {
+		return nil, fmt.Errorf("invalid cluster policy %s/%s: %s", PolicyConfigMapNamespace, PolicyConfigMapName, err.Error())
+	}
+	return getDeploymentPolicies(deployment, clusterPolicy)
+}
+
+// getDeploymentPolicies returns every policy that applies to a deployment, a job must satisfy all
+// of them.
+func getDeploymentPolicies(deployment *appv1.Deployment, clusterPolicy *ClusterPolicy) ([]sourcedPolicy, error) {
+	policies := []sourcedPolicy{}
+	if clusterPolicy != nil {
+		if clusterPolicy.Default != nil {
+			policies = append(policies, sourcedPolicy{"cluster default policy", clusterPolicy.Default})
+		}
+		key := deployment.Namespace + "/" + deployment.Name
+		if p, ok := clusterPolicy.Deployments[key]; ok {
+			policies = append(policies, sourcedPolicy{"cluster policy for " + key, p})
+		}
+	}
+
+	if annotation, ok := deployment.Annotations[PolicyAnnotationKey]; ok {
+		p := &Policy{}
+		if err := json.Unmarshal([]byte(annotation), p); err != nil {
+			return nil, fmt.Errorf("invalid policy annotation %s: %s", PolicyAnnotationKey, err.Error())
+		}
+		policies = append(policies, sourcedPolicy{"deployment policy", p})
+	}
+	return policies, nil
+}
+
+// evaluatePolicies returns a message for every rule the job breaks.
+func evaluatePolicies(policies []sourcedPolicy, deployment *appv1.Deployment, opts *JobOptions) []string {
+	violations := []string{}
+	for _, sp := range policies {
+		for _, v := range evaluatePolicy(sp.Policy, deployment, opts) {
+			violations = append(violations, fmt.Sprintf("%s (%s)", v, sp.Source))
+		}
+	}
+	return violations
+}
+
+func evaluatePolicy(policy *Policy, deployment *appv1.Deployment, opts *JobOptions) []string {
+	violations := []string{}
+
+	if len(policy.AllowedCommands) > 0 {
+		allowed := false
+		for _, pattern := range policy.AllowedCommands {
+			matched, err := matchPolicyPattern(pattern, opts.Command)
+			if err != nil {
+				violations = append(violations, err.Error())
+			}
+			allowed = allowed || matched
+		}
+		if !allowed {
+			violations = append(violations, "The command doesn't match any of the allowed patterns: "+strings.Join(policy.AllowedCommands, ", "))
+		}
+	}
+
+	for _, pattern := range policy.DeniedCommands {
+		matched, err := matchPolicyPattern(pattern, opts.Command)
+		if err != nil {
+			violations = append(violations, err.Error())
+		} else if matched {
+			violations = append(violations, "The command matches the denied pattern "+pattern)
+		}
+	}
+
+	for _, substring := range policy.ForbiddenSubstrings {
+		if strings.Contains(opts.Command, substring) {
+			violations = append(violations, fmt.Sprintf("The command contains the forbidden text %q", substring))
+		}
+	}
+
+	deadline := getDeadlineSeconds(opts)
+	if policy.MaxDeadlineSeconds > 0 && deadline > policy.MaxDeadlineSeconds {
+		violations = append(violations, fmt.Sprintf("The deadline of %s exceeds the maximum of %s", time.Duration(deadline)*time.Second, time.Duration(policy.MaxDeadlineSeconds)*time.Second))
+	}
+
+	// Only overrides are checked, the deployment's own image tag is always allowed
+	if len(policy.AllowedImageTags) > 0 && opts.ImageTagOverride != "" && (deployment == nil || opts.ImageTagOverride != getPrimaryContainerImageTag(deployment, "")) {
+		allowed := false
+		for _, pattern := range policy.AllowedImageTags {
+			matched, err := matchPolicyPattern(pattern, opts.ImageTagOverride)
+			if err != nil {
+				violations = append(violations, err.Error())
+			}
+			allowed = allowed || matched
+		}
+		if !allowed {
+			violations = append(violations, fmt.Sprintf("The image tag %s doesn't match any of the allowed patterns: %s", opts.ImageTagOverride, strings.Join(policy.AllowedImageTags, ", ")))
+		}
+	}
+
+	return violations
+}
+
+// matchPolicyPattern matches the whole value against a pattern, so that "rake db:.*" doesn't allow
+// "rake db:migrate; rm -rf /".
+func matchPolicyPattern(pattern, value string) (bool, error) {
+	r, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return false, fmt.Errorf("Invalid policy pattern %s: %s", pattern, err.Error())
+	}
+	return r.MatchString(value), nil
+}
+
+func getDeadlineSeconds(opts *JobOptions) int64 {
+	if opts.DeadlineSeconds > 0 {
+		return opts.DeadlineSeconds
+	}
+	return DefaultDeadlineSeconds
+}
+
+// policyTest evaluates a command against the policies of a deployment without creating a job. When
+// a policy file is given, the cluster isn't contacted at all.
+func policyTest(namespace, deploymentName string, opts *JobOptions, policyFile string) {
+	var policies []sourcedPolicy
+	var deployment *appv1.Deployment
+	var err error
+
+	if policyFile != "" {
+		policies, err = readPolicyFile(namespace, deploymentName, policyFile)
+	} else {
+		clientset := getClient()
+		deployment, err = clientset.AppsV1().Deployments(namespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
+		if err != nil {
+			fmt.Printf("Error getting deployment: %s\n", err.Error())
+			os.Exit(1)
+		}
+		policies, err = loadPolicies(clientset, deployment)
+	}
+	if err != nil {
+		fmt.Printf("Error loading policies: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if len(policies) == 0 {
+		fmt.Println("No policies apply to this deployment, any command is allowed")
+		return
+	}
+	violations := evaluatePolicies(policies, deployment, opts)
+	if len(violations) == 0 {
+		fmt.Println("The job is allowed " + emoji("✅"))
+		return
+	}
+	printProblems(violations)
+	os.Exit(1)
+}
+
+// readPolicyFile reads either a cluster policy or a single deployment policy from a local file.
+func readPolicyFile(namespace, deploymentName, policyFile string) ([]sourcedPolicy, error) {
+	data, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+	clusterPolicy := &ClusterPolicy{}
+	if err := json.Unmarshal(data, clusterPolicy); err != nil {
+		return nil, err
+	}
+	if clusterPolicy.Default != nil || clusterPolicy.Deployments != nil {
+		deployment := &appv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: deploymentName}}
+		return getDeploymentPolicies(deployment, clusterPolicy)
+	}
+
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	return []sourcedPolicy{{policyFile, p}}, nil
+}
+
+func printProblems(violations []string) {
+	red.Println("The job can't be created because of the following problems:")
+	for _, v := range violations {
+		fmt.Print("  - ")
+		red.Println(v)
+	}
+}
@@ -0,0 +1,112 @@
+package jobify
+
+import (
+	"reflect"
+	"testing"
+
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEvaluatePolicies(t *testing.T) {
+	deployment := &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: appv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "web", Image: "example/web:v1"}},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		policies []sourcedPolicy
+		opts     *JobOptions
+		want     []string
+	}{
+		{
+			name:     "no policies",
+			policies: []sourcedPolicy{},
+			opts:     &JobOptions{Command: "rm -rf /"},
+			want:     []string{},
+		},
+		{
+			name:     "allowed command",
+			policies: []sourcedPolicy{{"deployment policy", &Policy{AllowedCommands: []string{"rake db:.*"}}}},
+			opts:     &JobOptions{Command: "rake db:migrate"},
+			want:     []string{},
+		},
+		{
+			name:     "allowed patterns match the whole command",
+			policies: []sourcedPolicy{{"deployment policy", &Policy{AllowedCommands: []string{"rake db:.*", "ls"}}}},
+			opts:     &JobOptions{Command: "ls; rm -rf /"},
+			want:     []string{"The command doesn't match any of the allowed patterns: rake db:.*, ls (deployment policy)"},
+		},
+		{
+			name:     "denied command",
+			policies: []sourcedPolicy{{"cluster default policy", &Policy{DeniedCommands: []string{"rm .*"}}}},
+			opts:     &JobOptions{Command: "rm -rf /tmp"},
+			want:     []string{"The command matches the denied pattern rm .* (cluster default policy)"},
+		},
+		{
+			name:     "forbidden substring",
+			policies: []sourcedPolicy{{"deployment policy", &Policy{ForbiddenSubstrings: []string{"sudo"}}}},
+			opts:     &JobOptions{Command: "echo 1 && sudo reboot"},
+			want:     []string{`The command contains the forbidden text "sudo" (deployment policy)`},
+		},
+		{
+			name:     "invalid pattern",
+			policies: []sourcedPolicy{{"deployment policy", &Policy{DeniedCommands: []string{"("}}}},
+			opts:     &JobOptions{Command: "ls"},
+			want:     []string{"Invalid policy pattern (: error parsing regexp: missing closing ): `^(?:()$` (deployment policy)"},
+		},
+		{
+			name:     "default deadline exceeds the maximum",
+			policies: []sourcedPolicy{{"deployment policy", &Policy{MaxDeadlineSeconds: 3600}}},
+			opts:     &JobOptions{Command: "ls"},
+			want:     []string{"The deadline of 24h0m0s exceeds the maximum of 1h0m0s (deployment policy)"},
+		},
+		{
+			name:     "deadline within the maximum",
+			policies: []sourcedPolicy{{"deployment policy", &Policy{MaxDeadlineSeconds: 3600}}},
+			opts:     &JobOptions{Command: "ls", DeadlineSeconds: 600},
+			want:     []string{},
+		},
+		{
+			name:     "deployment image tag is always allowed",
+			policies: []sourcedPolicy{{"deployment policy", &Policy{AllowedImageTags: []string{"release-.*"}}}},
+			opts:     &JobOptions{Command: "ls", ImageTagOverride: "v1"},
+			want:     []string{},
+		},
+		{
+			name:     "image tag override",
+			policies: []sourcedPolicy{{"deployment policy", &Policy{AllowedImageTags: []string{"release-.*"}}}},
+			opts:     &JobOptions{Command: "ls", ImageTagOverride: "dev"},
+			want:     []string{"The image tag dev doesn't match any of the allowed patterns: release-.* (deployment policy)"},
+		},
+		{
+			name: "every policy applies",
+			policies: []sourcedPolicy{
+				{"cluster default policy", &Policy{DeniedCommands: []string{"rm .*"}}},
+				{"deployment policy", &Policy{ForbiddenSubstrings: []string{"/"}}},
+			},
+			opts: &JobOptions{Command: "rm -rf /"},
+			want: []string{
+				"The command matches the denied pattern rm .* (cluster default policy)",
+				`The command contains the forbidden text "/" (deployment policy)`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := evaluatePolicies(tt.policies, deployment, tt.opts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evaluatePolicies() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
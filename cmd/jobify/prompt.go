@@ -3,8 +3,11 @@ package jobify
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/manifoldco/promptui"
@@ -14,13 +17,16 @@ import (
 )
 
 var (
-	cyan  *color.Color = color.New(color.FgCyan)
-	faint *color.Color = color.New(color.Faint)
+	cyan   *color.Color = color.New(color.FgCyan)
+	faint  *color.Color = color.New(color.Faint)
+	yellow *color.Color = color.New(color.FgYellow)
+	red    *color.Color = color.New(color.FgRed)
 )
 
 type DeploymentItem struct {
 	Name      string
 	Namespace string
+	Favorite  bool
 }
 
 type JobItem struct {
@@ -30,9 +36,11 @@ type JobItem struct {
 	Command   string
 	Status    string
 	CreatedAt string
-	Completed bool
-	Failed    bool
-	Active    bool
+	CreatedBy string
+	Reason    string
+	State     string
+	Icon      string
+	Context   string
 }
 
 type bellSkipper struct{}
@@ -80,68 +88,140 @@ func promptOperation() int {
 	return i
 }
 
-func printJobDetails(job *batchv1.Job, podList *corev1.PodList) {
-	fmt.Println()
-	fmt.Println("--------- Details ----------")
+func printJobDetails(w io.Writer, job *batchv1.Job, podList *corev1.PodList, events []corev1.Event) {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "--------- Details ----------")
 	completed, failed := checkJobCondition(job)
-	state := "Active ⏳"
-	if completed {
-		state = "Completed ✅"
-	} else if failed {
-		state = "Failed ❌"
-	}
-	printAttribute("Name", job.Name)
-	printAttribute("Namespace", job.Namespace)
-	printAttribute("State", state)
+	state, icon := getJobState(job)
+	fprintAttribute(w, "Name", job.Name)
+	fprintAttribute(w, "Namespace", job.Namespace)
+	fprintAttribute(w, "State", state+" "+icon)
 	if job.Annotations[SourceAliasAnnotationKey] != "" && job.Annotations[SourceAliasAnnotationKey] != job.Annotations[SourceDeploymentAnnotationKey] {
-		printAttribute("Deployment Alias", job.Annotations[SourceAliasAnnotationKey])
+		fprintAttribute(w, "Deployment Alias", job.Annotations[SourceAliasAnnotationKey])
+	}
+	fprintAttribute(w, "Deployment Name", job.Annotations[SourceDeploymentAnnotationKey])
+	fprintAttribute(w, "Created At", job.CreationTimestamp.String())
+	if job.Annotations[CreatedByAnnotationKey] != "" {
+		fprintAttribute(w, "Created By", job.Annotations[CreatedByAnnotationKey])
+	}
+	if job.Annotations[ReasonAnnotationKey] != "" {
+		fprintAttribute(w, "Reason", job.Annotations[ReasonAnnotationKey])
 	}
-	printAttribute("Deployment Name", job.Annotations[SourceDeploymentAnnotationKey])
-	printAttribute("Created At", job.CreationTimestamp.String())
-	printAttribute("Pod Stats", fmt.Sprintf("Active: %d, Succeeded: %d, Failed: %d", job.Status.Active, job.Status.Succeeded, job.Status.Failed))
-	if len(podList.Items) > 0 {
+	if job.Annotations[ApprovedByAnnotationKey] != "" {
+		fprintAttribute(w, "Approved By", job.Annotations[ApprovedByAnnotationKey])
+	}
+	if job.Annotations[RejectedByAnnotationKey] != "" {
+		fprintAttribute(w, "Rejected By", job.Annotations[RejectedByAnnotationKey])
+	}
+	fprintAttribute(w, "Pod Stats", fmt.Sprintf("Active: %d, Succeeded: %d, Failed: %d", job.Status.Active, job.Status.Succeeded, job.Status.Failed))
+	if isJobIndexed(job) {
+		printIndexProgress(w, job, podList)
+	} else if job.Spec.Completions != nil && *job.Spec.Completions > 1 {
+		fprintAttribute(w, "Completions", fmt.Sprintf("%d/%d", job.Status.Succeeded, *job.Spec.Completions))
+	}
+	if len(podList.Items) > 0 && isJobIndexed(job) {
+		fprintAttribute(w, "Use the following command with a pod from the indexes above to view its logs (NOTE: this will not work once pods are garbage collected)", "")
+		cyan.Fprintf(w, "kubectl logs -n %s <pod-name> --container=%s\n", job.Namespace, job.Annotations[PrimaryContainerAnnotationKey])
+	} else if len(podList.Items) > 0 {
 		pods := podList.Items
 		if len(podList.Items) > 2 {
 			pods = pods[len(pods)-2:]
-			printAttribute("Pods (last two)", "")
+			fprintAttribute(w, "Pods (last two)", "")
 		} else {
-			printAttribute("Pods", "")
+			fprintAttribute(w, "Pods", "")
 		}
 		for _, p := range pods {
-			printAttributeWithIndentation(p.Name, "", 1)
-			printAttributeWithIndentation("Status", string(p.Status.Phase), 2)
-			printAttributeWithIndentation("Created At", p.CreationTimestamp.String(), 2)
+			fprintAttributeWithIndentation(w, p.Name, "", 1)
+			fprintAttributeWithIndentation(w, "Status", string(p.Status.Phase), 2)
+			fprintAttributeWithIndentation(w, "Created At", p.CreationTimestamp.String(), 2)
 			if p.Status.ContainerStatuses != nil && len(p.Status.ContainerStatuses) > 0 {
-				printAttributeWithIndentation("Containers", "", 2)
+				fprintAttributeWithIndentation(w, "Containers", "", 2)
 				for _, c := range p.Status.ContainerStatuses {
-					printAttributeWithIndentation(c.Name, getActiveContainerStateString(c.State), 3)
+					fprintAttributeWithIndentation(w, c.Name, getActiveContainerStateString(c.State), 3)
 				}
 			}
 		}
 
-		printAttribute("Use the following command to view logs (NOTE: this will not work once pods are garbage collected)", "")
-		cyan.Printf("kubectl logs -n %s -l job-name=%s --container=%s\n", job.Namespace, job.Name, job.Annotations["jobify/primary-container"])
+		fprintAttribute(w, "Use the following command to view logs (NOTE: this will not work once pods are garbage collected)", "")
+		cyan.Fprintf(w, "kubectl logs -n %s -l job-name=%s --container=%s\n", job.Namespace, job.Name, job.Annotations["jobify/primary-container"])
+	} else if approval := getApprovalState(job); approval == ApprovalPending || approval == ApprovalRejected {
+		fmt.Fprintln(w, "No pods found! The job doesn't start until it's approved")
 	} else if completed || failed {
-		fmt.Println("No pods found! Pods were likely garbage collected")
+		fmt.Fprintln(w, "No pods found! Pods were likely garbage collected")
 	} else {
-		fmt.Println("No pods found! Either they're being created, or there is a problem with the job")
+		fmt.Fprintln(w, "No pods found! Either they're being created, or there is a problem with the job")
+	}
+	if archive, ok := job.Annotations[LogArchiveAnnotationKey]; ok {
+		fprintAttribute(w, "Archived Logs", archive)
 	}
-	if logURLTemplate, ok := job.Annotations[LogsURLTemplateAnnotationKey]; ok {
-		printAttribute("Visit the link below to view logs", "")
-		logsURL := logURLTemplate
-		logsURL = strings.Replace(logsURL, "$JOB", job.Name, -1)
-		logsURL = strings.Replace(logsURL, "$CONTAINER", job.Annotations[PrimaryContainerAnnotationKey], -1)
-		cyan.Println(logsURL)
+	if templates, err := getLinkTemplates(job.Annotations); err == nil && len(templates) > 0 {
+		replacer := getLinkReplacer(job, podList, templates)
+		for _, name := range getLinkNames(templates) {
+			fprintAttribute(w, "Visit the link below to view "+name, "")
+			cyan.Fprintln(w, replacer.Replace(templates[name]))
+		}
+	}
+	if len(events) > 0 {
+		fprintAttribute(w, "Events", "")
+		for _, e := range events {
+			printEvent(w, &e)
+		}
+	}
+	if hints := diagnoseJob(job, podList, events); len(hints) > 0 {
+		fprintAttribute(w, "Diagnosis", "")
+		for _, h := range hints {
+			fmt.Fprint(w, "  - ")
+			yellow.Fprintln(w, h)
+		}
+	}
+
+}
 
+// getJobState returns a label and an icon describing where a job is in its lifecycle.
+func getJobState(job *batchv1.Job) (string, string) {
+	completed, failed := checkJobCondition(job)
+	if completed {
+		return "Completed", emoji("✅")
+	} else if failed && job.Annotations[CancelledAnnotationKey] == "true" {
+		return "Cancelled", emoji("🚫")
+	} else if failed {
+		return "Failed", emoji("❌")
+	}
+	switch getApprovalState(job) {
+	case ApprovalPending:
+		return "Pending approval", emoji("🔒")
+	case ApprovalRejected:
+		return "Rejected", emoji("⛔")
 	}
+	return "Active", emoji("⏳")
+}
 
+func printEvent(w io.Writer, event *corev1.Event) {
+	eventColor := faint
+	if event.Type == corev1.EventTypeWarning {
+		eventColor = yellow
+	}
+	count := ""
+	if event.Count > 1 {
+		count = fmt.Sprintf(" (x%d)", event.Count)
+	}
+	fmt.Fprint(w, "  ")
+	faint.Fprintf(w, "%s ", getEventTime(event).Format("2006-01-02 15:04:05"))
+	eventColor.Fprintf(w, "%s %s/%s%s: ", event.Reason, event.InvolvedObject.Kind, event.InvolvedObject.Name, count)
+	fmt.Fprintln(w, event.Message)
 }
 
-func promptJobSelection(jobList *batchv1.JobList) int {
+// promptJobSelection lets the user select a job. The contexts are only given when the jobs come
+// from several kubeconfig contexts, one per job.
+func promptJobSelection(jobList *batchv1.JobList, contexts []string) int {
 	jobItems := []JobItem{}
 
-	for _, j := range jobList.Items {
-		completed, failed := checkJobCondition(&j)
+	for i, j := range jobList.Items {
+		jobContext := ""
+		if contexts != nil {
+			jobContext = contexts[i]
+		}
+		state, icon := getJobState(&j)
 		jobItems = append(jobItems, JobItem{
 			Name:      j.Name,
 			Namespace: j.Namespace,
@@ -149,17 +229,19 @@ func promptJobSelection(jobList *batchv1.JobList) int {
 			Command:   j.Annotations[UserCommandAnnotationKey],
 			Status:    fmt.Sprintf("Active: %d, Succeeded: %d, Failed: %d", j.Status.Active, j.Status.Succeeded, j.Status.Failed),
 			CreatedAt: j.GetCreationTimestamp().String(),
-			Completed: completed,
-			Failed:    failed,
-			Active:    !completed && !failed,
+			CreatedBy: j.Annotations[CreatedByAnnotationKey],
+			Reason:    j.Annotations[ReasonAnnotationKey],
+			State:     state,
+			Icon:      icon,
+			Context:   jobContext,
 		})
 	}
 
 	templates := &promptui.SelectTemplates{
 		Label:    "{{ . }}:",
-		Active:   "> {{ .Namespace | cyan }}/{{ .Name | cyan }}: {{ .Command }} {{ if .Completed }}✅{{ end }}{{ if .Failed }}❌{{ end }}{{ if .Active }}⏳{{ end }} {{ `Created at:` | faint }} {{ .CreatedAt | faint }}",
-		Inactive: "  {{ .Namespace | cyan }}/{{ .Name | cyan }}: {{ .Command }} {{ if .Completed }}✅{{ end }}{{ if .Failed }}❌{{ end }}{{ if .Active }}⏳{{ end }} {{ `Created at:` | faint }} {{ .CreatedAt | faint }}",
-		Selected: "Selected {{ .Namespace | cyan }}/{{ .Name | cyan }}",
+		Active:   "> {{ if .Context }}{{ .Context | yellow }} {{ end }}{{ .Namespace | cyan }}/{{ .Name | cyan }}: {{ .Command }} {{ .Icon }} {{ `Created at:` | faint }} {{ .CreatedAt | faint }}{{ if .CreatedBy }} {{ `by` | faint }} {{ .CreatedBy | faint }}{{ end }}",
+		Inactive: "  {{ if .Context }}{{ .Context | yellow }} {{ end }}{{ .Namespace | cyan }}/{{ .Name | cyan }}: {{ .Command }} {{ .Icon }} {{ `Created at:` | faint }} {{ .CreatedAt | faint }}{{ if .CreatedBy }} {{ `by` | faint }} {{ .CreatedBy | faint }}{{ end }}",
+		Selected: "Selected {{ if .Context }}{{ .Context | yellow }} {{ end }}{{ .Namespace | cyan }}/{{ .Name | cyan }}",
 		// 		Details: `
 		// --------- Info ----------
 		// {{ "Source deployment:" | faint }}	{{ .Source }}
@@ -169,7 +251,7 @@ func promptJobSelection(jobList *batchv1.JobList) int {
 
 	searcher := func(input string, index int) bool {
 		item := jobItems[index]
-		name := strings.Replace(strings.ToLower(item.Name), " ", "", -1) + item.Command + item.Namespace
+		name := strings.Replace(strings.ToLower(item.Name), " ", "", -1) + item.Command + item.Namespace + item.CreatedBy + item.Reason + item.Context
 		input = strings.Replace(strings.ToLower(input), " ", "", -1)
 
 		return strings.Contains(name, input)
@@ -204,13 +286,14 @@ func promptDeploymentSelection(deployments *appv1.DeploymentList) int {
 		deploymentItems = append(deploymentItems, DeploymentItem{
 			Name:      getDeploymentName(&d),
 			Namespace: d.Namespace,
+			Favorite:  isFavoriteDeployment(&d),
 		})
 	}
 
 	templates := &promptui.SelectTemplates{
 		Label:    "{{ . }}:",
-		Active:   "> {{ .Namespace | cyan }}/{{ .Name | cyan }}",
-		Inactive: "  {{ .Namespace | cyan }}/{{ .Name | cyan }}",
+		Active:   "> {{ .Namespace | cyan }}/{{ .Name | cyan }}{{ if .Favorite }} {{ `(favorite)` | faint }}{{ end }}",
+		Inactive: "  {{ .Namespace | cyan }}/{{ .Name | cyan }}{{ if .Favorite }} {{ `(favorite)` | faint }}{{ end }}",
 		Selected: "Selected {{ .Namespace | cyan }}/{{ .Name | cyan }}",
 	}
 
@@ -244,10 +327,11 @@ func promptDeploymentSelection(deployments *appv1.DeploymentList) int {
 	return i
 }
 
-func promptConfirmation(deployment *appv1.Deployment, imageTagOverride, userCommand string) (confirmed bool, outputimageTagOverride, outputCommand string) {
+func promptConfirmation(deployment *appv1.Deployment, opts *JobOptions, check func(opts *JobOptions) (problems, warnings []string)) (confirmed bool) {
 
 	for {
-		printConfirmationDetails(deployment, imageTagOverride, userCommand)
+		problems, warnings := check(opts)
+		printConfirmationDetails(deployment, opts, problems, warnings)
 		templates := &promptui.SelectTemplates{
 			Label:    "{{ . }}?",
 			Active:   "> {{ . | cyan }}",
@@ -260,10 +344,11 @@ func promptConfirmation(deployment *appv1.Deployment, imageTagOverride, userComm
 				"Confirm",
 				"Edit image tag",
 				"Edit command",
+				"Edit reason",
 				"Cancel",
 			},
 			Templates: templates,
-			Size:      4,
+			Size:      5,
 			Stdout:    &bellSkipper{},
 		}
 
@@ -279,38 +364,107 @@ func promptConfirmation(deployment *appv1.Deployment, imageTagOverride, userComm
 
 		switch i {
 		case 0:
-			return true, imageTagOverride, userCommand
+			if len(problems) > 0 {
+				yellow.Println("The job can't be created until the problems above are fixed")
+				continue
+			}
+			if requiresReason(deployment) && opts.Reason == "" {
+				yellow.Println("This deployment requires a reason for every job, please enter one")
+				opts.Reason = promptReason(opts.Reason, true)
+				continue
+			}
+			return true
 		case 1:
-			imageTagOverride = promptImageTag(getPrimaryContainerImageTag(deployment, imageTagOverride))
+			opts.ImageTagOverride = promptImageTag(getPrimaryContainerImageTag(deployment, opts.ImageTagOverride))
 		case 2:
-			userCommand = promptCommand(userCommand)
+			opts.Command = expandCommandAlias(promptCommand(opts.Command))
 		case 3:
-			return false, imageTagOverride, userCommand
+			opts.Reason = promptReason(opts.Reason, requiresReason(deployment))
+		case 4:
+			return false
 		}
 
 	}
 }
 
-func printConfirmationDetails(deployment *appv1.Deployment, imageTagOverride, userCommand string) {
+func printConfirmationDetails(deployment *appv1.Deployment, opts *JobOptions, problems, warnings []string) {
 	fmt.Println("")
 	fmt.Println("Job details:")
 	printAttribute("Deployment Name", getDeploymentName(deployment))
 	printAttribute("Namespace", deployment.Namespace)
-	printAttribute("Image Tag", getPrimaryContainerImageTag(deployment, imageTagOverride))
-	printAttribute("Command", userCommand)
+	if len(opts.Contexts) > 0 {
+		printAttribute("Contexts", strings.Join(opts.Contexts, ", "))
+	}
+	printAttribute("Image Tag", getPrimaryContainerImageTag(deployment, opts.ImageTagOverride))
+	if opts.Preset != "" {
+		printAttribute("Preset", opts.Preset)
+	}
+	printAttribute("Command", opts.Command)
+	printAttribute("Created By", opts.CreatedBy)
+	if opts.Reason != "" {
+		printAttribute("Reason", opts.Reason)
+	} else if requiresReason(deployment) {
+		printAttribute("Reason", "(required)")
+	}
+	printAttribute("Deadline", (time.Duration(getDeadlineSeconds(opts)) * time.Second).String())
+	printPlacement(getJobPlacement(deployment, opts))
+	printSidecarOptions(deployment, opts)
+	printPodOverrides(deployment, opts)
+	printFiles(opts)
+	if opts.CollectPath != "" {
+		printAttribute("Collect", opts.CollectPath+" (the pod waits until it's downloaded with jobify cp)")
+	}
+	if opts.Parallelism > 0 {
+		printAttribute("Parallelism", fmt.Sprint(opts.Parallelism))
+	}
+	if opts.Completions > 0 {
+		printAttribute("Completions", fmt.Sprint(opts.Completions))
+	}
+	if opts.Indexed {
+		printAttribute("Completion Mode", "Indexed ($JOBIFY_INDEX is replaced by each pod's index)")
+	}
+	if len(opts.Env) > 0 {
+		env := []string{}
+		for name, value := range opts.Env {
+			env = append(env, name+"="+value)
+		}
+		sort.Strings(env)
+		printAttribute("Environment", strings.Join(env, ", "))
+	}
+	if opts.Resources != nil {
+		printAttribute("Resources", getResourcesString(opts.Resources))
+	}
+	if len(opts.ForEachItems) > 0 {
+		printAttribute("Items", fmt.Sprintf("%d, one job per item", len(opts.ForEachItems)))
+		printAttribute("First Job's Command", getItemCommand(opts.Command, opts.ForEachItems[0]))
+	}
+	if len(warnings) > 0 {
+		fmt.Println()
+		for _, w := range warnings {
+			yellow.Println("Warning: " + w)
+		}
+	}
+	if len(problems) > 0 {
+		fmt.Println()
+		printProblems(problems)
+	}
 }
 
 func printAttribute(key, value string) {
-	faint.Print(key + ": ")
-	cyan.Println(value)
+	fprintAttribute(color.Output, key, value)
 }
 
-func printAttributeWithIndentation(key, value string, indentation int) {
+func fprintAttribute(w io.Writer, key, value string) {
+	faint.Fprint(w, key+": ")
+	cyan.Fprintln(w, value)
+}
+
+func fprintAttributeWithIndentation(w io.Writer, key, value string, indentation int) {
 	for i := 0; i < indentation; i++ {
-		fmt.Print("  ")
+		fmt.Fprint(w, "  ")
 	}
-	faint.Print(key + ": ")
-	cyan.Println(value)
+	faint.Fprint(w, key+": ")
+	cyan.Fprintln(w, value)
 }
 
 func promptCommand(defaultCommand string) string {
@@ -361,3 +515,31 @@ func promptImageTag(currentTag string) string {
 
 	return result
 }
+
+func promptReason(currentReason string, required bool) string {
+	validate := func(input string) error {
+		if required && strings.TrimSpace(input) == "" {
+			return errors.New("Must enter a reason")
+		}
+		return nil
+	}
+
+	prompt := promptui.Prompt{
+		Label:     "Enter the reason or ticket for this job",
+		Default:   currentReason,
+		Validate:  validate,
+		AllowEdit: true,
+	}
+
+	result, err := prompt.Run()
+
+	if err != nil {
+		if err == promptui.ErrInterrupt {
+			fmt.Println("The command was interrupted ^C")
+			os.Exit(1)
+		}
+		panic(err.Error())
+	}
+
+	return strings.TrimSpace(result)
+}
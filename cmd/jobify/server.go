@@ -0,0 +1,466 @@
+package jobify
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	APITokenEnvVar = "JOBIFY_API_TOKEN"
+	// APIUserHeader names the user a job is created for, e.g. the one logged into a portal. Anyone
+	// with the token could set it, so it's only used behind a proxy that authenticates users and
+	// sets it itself.
+	APIUserHeader  = "X-Jobify-User"
+	defaultAPIUser = "jobify-api"
+	apiPrefix      = "/api/v1/"
+)
+
+// apiServer serves the REST API of jobify serve, over the same logic as the commands.
+type apiServer struct {
+	clientset       *kubernetes.Clientset
+	namespace       string
+	token           string
+	trustUserHeader bool
+}
+
+type apiError struct {
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+}
+
+type apiDeployment struct {
+	Namespace        string            `json:"namespace"`
+	Name             string            `json:"name"`
+	Alias            string            `json:"alias,omitempty"`
+	DefaultCommand   string            `json:"defaultCommand,omitempty"`
+	Presets          map[string]string `json:"presets,omitempty"`
+	RequiresReason   bool              `json:"requiresReason"`
+	RequiresApproval bool              `json:"requiresApproval"`
+	Error            string            `json:"error,omitempty"`
+}
+
+type apiContainer struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+type apiPod struct {
+	Name       string         `json:"name"`
+	Phase      string         `json:"phase"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	Containers []apiContainer `json:"containers"`
+}
+
+type apiJob struct {
+	Namespace   string            `json:"namespace"`
+	Name        string            `json:"name"`
+	Deployment  string            `json:"deployment"`
+	Command     string            `json:"command,omitempty"`
+	State       string            `json:"state"`
+	CreatedBy   string            `json:"createdBy,omitempty"`
+	Reason      string            `json:"reason,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	CompletedAt *time.Time        `json:"completedAt,omitempty"`
+	Active      int32             `json:"active"`
+	Succeeded   int32             `json:"succeeded"`
+	Failed      int32             `json:"failed"`
+	Links       map[string]string `json:"links,omitempty"`
+	Pods        []apiPod          `json:"pods,omitempty"`
+	Warnings    []string          `json:"warnings,omitempty"`
+}
+
+// apiCreateRequest is a task spec, like the ones of jobify apply.
+type apiCreateRequest struct {
+	TaskSpec
+	Force  bool `json:"force,omitempty"`
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string, details ...string) {
+	writeJSON(w, status, map[string]apiError{"error": {Code: code, Message: message, Details: details}})
+}
+
+// writeKubernetesError responds with the status of errors from the API server, e.g. not found.
+func writeKubernetesError(w http.ResponseWriter, err error) {
+	switch {
+	case apierrors.IsNotFound(err):
+		writeAPIError(w, http.StatusNotFound, "not_found", err.Error())
+	case apierrors.IsForbidden(err):
+		writeAPIError(w, http.StatusForbidden, "forbidden", err.Error())
+	case apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err):
+		writeAPIError(w, http.StatusConflict, "conflict", err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const bearer = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, bearer) || subtle.ConstantTimeCompare([]byte(auth[len(bearer):]), []byte(s.token)) != 1 {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "a valid bearer token is required")
+		return
+	}
+
+	// Routes: deployments, jobs, jobs/{namespace}/{name}, jobs/{namespace}/{name}/logs and
+	// jobs/{namespace}/{name}/cancel
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "deployments" && r.Method == http.MethodGet:
+		s.listDeployments(w, r)
+	case len(parts) == 1 && parts[0] == "jobs" && r.Method == http.MethodGet:
+		s.listJobs(w, r)
+	case len(parts) == 1 && parts[0] == "jobs" && r.Method == http.MethodPost:
+		s.createJob(w, r)
+	case len(parts) >= 3 && len(parts) <= 4 && parts[0] == "jobs":
+		action := ""
+		if len(parts) == 4 {
+			action = parts[3]
+		}
+		s.handleJob(w, r, parts[1], parts[2], action)
+	default:
+		writeAPIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("no route for %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// checkNamespace rejects namespaces that the server isn't limited to.
+func (s *apiServer) checkNamespace(w http.ResponseWriter, namespace string) bool {
+	if s.namespace != "" && namespace != s.namespace {
+		writeAPIError(w, http.StatusForbidden, "forbidden", fmt.Sprintf("the server only serves namespace %s", s.namespace))
+		return false
+	}
+	return true
+}
+
+func (s *apiServer) listDeployments(w http.ResponseWriter, r *http.Request) {
+	deploymentList, err := listJobifyDeployments(s.clientset, s.namespace)
+	if err != nil {
+		writeKubernetesError(w, err)
+		return
+	}
+	deployments := []apiDeployment{}
+	for i := range deploymentList.Items {
+		d := &deploymentList.Items[i]
+		item := apiDeployment{
+			Namespace:        d.Namespace,
+			Name:             d.Name,
+			DefaultCommand:   d.Annotations[DefaultCommandAnnotationKey],
+			RequiresReason:   requiresReason(d),
+			RequiresApproval: requiresApproval(d),
+		}
+		if alias := getDeploymentName(d); alias != d.Name {
+			item.Alias = alias
+		}
+		if err := validateDeployment(d); err != nil {
+			item.Error = err.Error()
+		} else if item.Presets, err = getPresets(d); err != nil {
+			item.Error = err.Error()
+		}
+		deployments = append(deployments, item)
+	}
+	writeJSON(w, http.StatusOK, deployments)
+}
+
+func (s *apiServer) listJobs(w http.ResponseWriter, r *http.Request) {
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = s.namespace
+	} else if !s.checkNamespace(w, namespace) {
+		return
+	}
+	jobList, err := listJobifyJobs(s.clientset, namespace)
+	if err != nil {
+		writeKubernetesError(w, err)
+		return
+	}
+	sort.Slice(jobList.Items, func(i, j int) bool {
+		return jobList.Items[i].CreationTimestamp.After(jobList.Items[j].CreationTimestamp.Time)
+	})
+	jobs := []*apiJob{}
+	for i := range jobList.Items {
+		jobs = append(jobs, getAPIJob(&jobList.Items[i], nil))
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// createJob creates a job from a task spec, with the same checks as jobify apply.
+func (s *apiServer) createJob(w http.ResponseWriter, r *http.Request) {
+	request := &apiCreateRequest{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(request); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "invalid request body: "+err.Error())
+		return
+	}
+	parts := strings.Split(request.Deployment, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "the deployment must be in the format \"namespace/name\"")
+		return
+	}
+	if err := request.validate(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if !s.checkNamespace(w, parts[0]) {
+		return
+	}
+	deployment, err := findDeployment(s.clientset, parts[0], parts[1])
+	if err != nil {
+		writeKubernetesError(w, err)
+		return
+	}
+	if err := validateDeployment(deployment); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_deployment", err.Error())
+		return
+	}
+	opts, err := request.getJobOptions(deployment)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	opts.CreatedBy = defaultAPIUser
+	if user := r.Header.Get(APIUserHeader); user != "" && s.trustUserHeader {
+		opts.CreatedBy = user
+	} else if user != "" {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("the %s header is only accepted when the server runs with --trust-user-header", APIUserHeader))
+		return
+	}
+	opts.Force = request.Force
+
+	checks, err := loadJobChecks(s.clientset, deployment)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "internal", "couldn't load policies: "+err.Error())
+		return
+	}
+	problems, warnings := checks(opts)
+	if requiresReason(deployment) && opts.Reason == "" {
+		problems = append(problems, "This deployment requires a reason for every job")
+	}
+	if len(problems) > 0 {
+		writeAPIError(w, http.StatusUnprocessableEntity, "checks_failed", "the job can't be created", problems...)
+		return
+	}
+
+	job := setupJob(deployment, opts)
+	if request.DryRun {
+		writeJSON(w, http.StatusOK, job)
+		return
+	}
+	var created *batchv1.Job
+	notify := func(message string) { warnings = append(warnings, message) }
+	err = createJobExclusively(s.clientset, deployment, opts, notify, func() error {
+		created, err = createJobResource(s.clientset, job, opts)
+		return err
+	})
+	if err != nil {
+		if _, ok := err.(apierrors.APIStatus); ok {
+			writeKubernetesError(w, err)
+		} else if conflict, ok := err.(*ConflictError); ok {
+			writeAPIError(w, http.StatusConflict, "conflict", err.Error(), conflict.Problems...)
+		} else if err == errLeaseTimeout {
+			writeAPIError(w, http.StatusServiceUnavailable, "unavailable", err.Error())
+		} else {
+			writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+		}
+		return
+	}
+	response := getAPIJob(created, nil)
+	response.Warnings = warnings
+	writeJSON(w, http.StatusCreated, response)
+}
+
+func (s *apiServer) handleJob(w http.ResponseWriter, r *http.Request, namespace, name, action string) {
+	if !s.checkNamespace(w, namespace) {
+		return
+	}
+	job, err := s.clientset.BatchV1().Jobs(namespace).Get(r.Context(), name, metav1.GetOptions{})
+	if err != nil {
+		writeKubernetesError(w, err)
+		return
+	}
+	if job.Labels["jobify"] != "true" {
+		writeAPIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("job %s/%s wasn't created by jobify", namespace, name))
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		podList, err := listJobPods(s.clientset, job)
+		if err != nil {
+			writeKubernetesError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, getAPIJob(job, podList))
+	case action == "logs" && r.Method == http.MethodGet:
+		s.streamLogs(w, r, job)
+	case action == "cancel" && r.Method == http.MethodPost:
+		if completed, failed := checkJobCondition(job); completed || failed {
+			writeAPIError(w, http.StatusConflict, "conflict", fmt.Sprintf("job %s/%s has already finished", namespace, name))
+			return
+		}
+		if err := cancelJob(s.clientset, job); err != nil {
+			writeKubernetesError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeAPIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("no route for %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// streamLogs sends the logs of a pod's container as server-sent events, one line per event, and an
+// end event once they're over. It defaults to the primary container of the newest pod.
+func (s *apiServer) streamLogs(w http.ResponseWriter, r *http.Request, job *batchv1.Job) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "internal", "streaming isn't supported")
+		return
+	}
+	podList, err := listJobPods(s.clientset, job)
+	if err != nil {
+		writeKubernetesError(w, err)
+		return
+	}
+	podName := r.URL.Query().Get("pod")
+	var pod *corev1.Pod
+	for i := range podList.Items {
+		p := &podList.Items[i]
+		if podName == p.Name || podName == "" && (pod == nil || p.CreationTimestamp.After(pod.CreationTimestamp.Time)) {
+			pod = p
+		}
+	}
+	if pod == nil {
+		writeAPIError(w, http.StatusNotFound, "not_found", "the job has no such pod, pods may have been garbage collected")
+		return
+	}
+	container := r.URL.Query().Get("container")
+	if container == "" {
+		container = job.Annotations[PrimaryContainerAnnotationKey]
+	}
+
+	req := s.clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: container,
+		Follow:    r.URL.Query().Get("follow") != "false",
+	})
+	podLogs, err := req.Stream(r.Context())
+	if err != nil {
+		writeKubernetesError(w, err)
+		return
+	}
+	defer podLogs.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	scanner := bufio.NewScanner(podLogs)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fmt.Fprintf(w, "data: %s\n\n", scanner.Text())
+		flusher.Flush()
+	}
+	if err := scanner.Err(); err != nil && r.Context().Err() == nil {
+		data, _ := json.Marshal(apiError{Code: "internal", Message: err.Error()})
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+	}
+	fmt.Fprintf(w, "event: end\ndata: {\"pod\":%q,\"container\":%q}\n\n", pod.Name, container)
+	flusher.Flush()
+}
+
+func getAPIJob(job *batchv1.Job, podList *corev1.PodList) *apiJob {
+	state, _ := getJobState(job)
+	j := &apiJob{
+		Namespace:  job.Namespace,
+		Name:       job.Name,
+		Deployment: job.Annotations[SourceDeploymentAnnotationKey],
+		Command:    job.Annotations[UserCommandAnnotationKey],
+		State:      state,
+		CreatedBy:  job.Annotations[CreatedByAnnotationKey],
+		Reason:     job.Annotations[ReasonAnnotationKey],
+		CreatedAt:  job.CreationTimestamp.Time,
+		Active:     job.Status.Active,
+		Succeeded:  job.Status.Succeeded,
+		Failed:     job.Status.Failed,
+	}
+	if completed, failed := checkJobCondition(job); completed || failed {
+		completedAt := getJobCompletionTime(job)
+		j.CompletedAt = &completedAt
+	}
+	if templates, err := getLinkTemplates(job.Annotations); err == nil && len(templates) > 0 {
+		replacer := getLinkReplacer(job, podList, templates)
+		j.Links = map[string]string{}
+		for name, template := range templates {
+			j.Links[name] = replacer.Replace(template)
+		}
+	}
+	if podList != nil {
+		j.Pods = []apiPod{}
+		for _, p := range podList.Items {
+			pod := apiPod{Name: p.Name, Phase: string(p.Status.Phase), CreatedAt: p.CreationTimestamp.Time, Containers: []apiContainer{}}
+			for _, c := range p.Status.ContainerStatuses {
+				pod.Containers = append(pod.Containers, apiContainer{Name: c.Name, State: getActiveContainerStateString(c.State)})
+			}
+			j.Pods = append(j.Pods, pod)
+		}
+	}
+	return j
+}
+
+// getAPIToken reads the token from a file, or from JOBIFY_API_TOKEN.
+func getAPIToken(tokenFile string) (string, error) {
+	token := os.Getenv(APITokenEnvVar)
+	if tokenFile != "" {
+		data, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return "", err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return "", fmt.Errorf("set a token with --token-file or %s, the API can't be served without one", APITokenEnvVar)
+	}
+	return token, nil
+}
+
+func serve(clientset *kubernetes.Clientset, namespace, address, tokenFile string, trustUserHeader bool) {
+	token, err := getAPIToken(tokenFile)
+	if err != nil {
+		fmt.Printf("Error reading the API token: %s\n", err.Error())
+		os.Exit(1)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(apiPrefix, &apiServer{clientset: clientset, namespace: namespace, token: token, trustUserHeader: trustUserHeader})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Printf("Serving the API on %s%s\n", address, apiPrefix)
+	if err := server.ListenAndServe(); err != nil {
+		fmt.Printf("Error serving the API: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
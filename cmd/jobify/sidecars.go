@@ -0,0 +1,152 @@
+package jobify
+
+import (
+	"fmt"
+	"strings"
+
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	DropContainersAnnotationKey    = "jobify/drop-containers"
+	TerminateSidecarsAnnotationKey = "jobify/terminate-sidecars"
+	// terminateSidecarsScript runs the command, then signals every process of the pod it can see,
+	// which thanks to the shared process namespace includes the sidecars, and keeps the command's
+	// exit code. The pause container is PID 1, so kill -1 doesn't signal it. Errors are kept in
+	// the logs, since processes of other users can't be signalled unless the command runs as root.
+	terminateSidecarsScript = `"$@"; rc=$?; kill -TERM -1; exit $rc`
+)
+
+// getDroppedContainers returns the containers of the deployment that are left out of its jobs, from
+// the deployment's annotation and the job's options.
+func getDroppedContainers(deployment *appv1.Deployment, opts *JobOptions) []string {
+	dropped := []string{}
+	seen := map[string]bool{}
+	for _, name := range append(strings.Split(deployment.Annotations[DropContainersAnnotationKey], ","), opts.DropContainers...) {
+		if name = strings.TrimSpace(name); name != "" && !seen[name] {
+			seen[name] = true
+			dropped = append(dropped, name)
+		}
+	}
+	return dropped
+}
+
+func shouldTerminateSidecars(deployment *appv1.Deployment, opts *JobOptions) bool {
+	return opts.TerminateSidecars || deployment.Annotations[TerminateSidecarsAnnotationKey] == "true"
+}
+
+func checkSidecarOptions(deployment *appv1.Deployment, opts *JobOptions) []string {
+	problems := []string{}
+	containers := deployment.Spec.Template.Spec.Containers
+	primaryContainerName := containers[getPrimaryContainer(deployment)].Name
+	for _, name := range getDroppedContainers(deployment, opts) {
+		found := false
+		for _, c := range containers {
+			found = found || c.Name == name
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("The deployment has no container %s to drop", name))
+		} else if name == primaryContainerName {
+			problems = append(problems, fmt.Sprintf("Container %s runs the command, it can't be dropped", name))
+		}
+	}
+	return problems
+}
+
+// checkSidecarWarnings warns about the sidecars that the primary container likely can't terminate,
+// because they run as another user and it doesn't run as root.
+func checkSidecarWarnings(deployment *appv1.Deployment, opts *JobOptions) []string {
+	warnings := []string{}
+	if !shouldTerminateSidecars(deployment, opts) {
+		return warnings
+	}
+	podSpec := &deployment.Spec.Template.Spec
+	dropped := getDroppedContainers(deployment, opts)
+	primary := podSpec.Containers[getPrimaryContainer(deployment)]
+	primaryUser := getContainerUser(podSpec, &primary)
+	if primaryUser != nil && *primaryUser == 0 {
+		return warnings
+	}
+	for i := range podSpec.Containers {
+		c := &podSpec.Containers[i]
+		if c.Name == primary.Name || containsString(dropped, c.Name) {
+			continue
+		}
+		user := getContainerUser(podSpec, c)
+		if (user == nil) != (primaryUser == nil) || (user != nil && *user != *primaryUser) {
+			warnings = append(warnings, fmt.Sprintf("Sidecar %s may run as a different user than %s, it won't be terminated when the command exits unless %s runs as root", c.Name, primary.Name, primary.Name))
+		}
+	}
+	return warnings
+}
+
+// getContainerUser returns the user a container runs as, or nil when it's the image's user.
+func getContainerUser(podSpec *corev1.PodSpec, container *corev1.Container) *int64 {
+	if container.SecurityContext != nil && container.SecurityContext.RunAsUser != nil {
+		return container.SecurityContext.RunAsUser
+	}
+	if podSpec.SecurityContext != nil {
+		return podSpec.SecurityContext.RunAsUser
+	}
+	return nil
+}
+
+// setupJobSidecars drops the unwanted containers from a job, and makes the primary container
+// terminate the remaining ones when it exits, since a job's pod only completes once all of its
+// containers have exited. Sidecars should exit with 0 on SIGTERM, otherwise the pod fails.
+func setupJobSidecars(job *batchv1.Job, deployment *appv1.Deployment, opts *JobOptions) {
+	dropped := getDroppedContainers(deployment, opts)
+	podSpec := &job.Spec.Template.Spec
+	containers := []corev1.Container{}
+	for _, c := range podSpec.Containers {
+		if !containsString(dropped, c.Name) {
+			containers = append(containers, c)
+		}
+	}
+	podSpec.Containers = containers
+
+	if shouldTerminateSidecars(deployment, opts) && len(podSpec.Containers) > 1 {
+		for i := range podSpec.Containers {
+			c := &podSpec.Containers[i]
+			if c.Name == job.Annotations[PrimaryContainerAnnotationKey] {
+				c.Command = append([]string{"sh", "-c", terminateSidecarsScript, "sh"}, c.Command...)
+			}
+		}
+	}
+
+	// Only the options are recorded, reruns get the deployment's annotations again
+	if len(opts.DropContainers) > 0 {
+		job.Annotations[DropContainersAnnotationKey] = strings.Join(opts.DropContainers, ",")
+	}
+	if opts.TerminateSidecars {
+		job.Annotations[TerminateSidecarsAnnotationKey] = "true"
+	}
+}
+
+func getJobSidecarOverrides(job *batchv1.Job, opts *JobOptions) {
+	if dropped, ok := job.Annotations[DropContainersAnnotationKey]; ok {
+		opts.DropContainers = strings.Split(dropped, ",")
+	}
+	opts.TerminateSidecars = job.Annotations[TerminateSidecarsAnnotationKey] == "true"
+}
+
+func printSidecarOptions(deployment *appv1.Deployment, opts *JobOptions) {
+	dropped := getDroppedContainers(deployment, opts)
+	if len(dropped) > 0 {
+		printAttribute("Dropped Containers", strings.Join(dropped, ", "))
+	}
+	if shouldTerminateSidecars(deployment, opts) && len(deployment.Spec.Template.Spec.Containers)-len(dropped) > 1 {
+		printAttribute("Sidecars", "Terminated when the command exits")
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
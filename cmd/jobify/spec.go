@@ -0,0 +1,324 @@
+package jobify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	appv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	PresetsAnnotationKey      = "jobify/presets"
+	PresetAnnotationKey       = "jobify/preset"
+	PresetParamsAnnotationKey = "jobify/preset-params"
+	EnvAnnotationKey          = "jobify/env"
+	ResourcesAnnotationKey    = "jobify/resources"
+)
+
+var presetParamRegexp = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// TaskSpec is a job described in a file, so that one-off tasks can be reviewed and reproduced. The
+// command is either given directly or rendered from one of the deployment's presets.
+type TaskSpec struct {
+	// Deployment is "namespace/name", the name can also be the deployment's alias
+	Deployment string                       `json:"deployment"`
+	Command    string                       `json:"command,omitempty"`
+	Preset     string                       `json:"preset,omitempty"`
+	Params     map[string]string            `json:"params,omitempty"`
+	ImageTag   string                       `json:"imageTag,omitempty"`
+	Env        map[string]string            `json:"env,omitempty"`
+	Resources  *corev1.ResourceRequirements `json:"resources,omitempty"`
+	Deadline   string                       `json:"deadline,omitempty"`
+	Reason     string                       `json:"reason,omitempty"`
+}
+
+func readTaskSpec(path string) (*TaskSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := &TaskSpec{}
+	if err := yaml.UnmarshalStrict(data, spec); err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %s", path, err.Error())
+	}
+	if !strings.Contains(spec.Deployment, "/") {
+		return nil, fmt.Errorf("invalid spec %s: the deployment must be in the format namespace/name", path)
+	}
+	if err := spec.validate(); err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %s", path, err.Error())
+	}
+	return spec, nil
+}
+
+// validate checks the fields that don't depend on the deployment, for specs read from files and
+// sent to the API alike.
+func (spec *TaskSpec) validate() error {
+	if (spec.Command == "") == (spec.Preset == "") {
+		return errors.New("exactly one of command and preset must be set")
+	}
+	if spec.Command != "" && len(spec.Params) > 0 {
+		return errors.New("params can only be used with a preset")
+	}
+	if spec.Deadline != "" {
+		if _, err := time.ParseDuration(spec.Deadline); err != nil {
+			return fmt.Errorf("invalid deadline: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// getJobOptions turns the spec into the options of a job from the deployment, rendering the
+// preset if there is one.
+func (spec *TaskSpec) getJobOptions(deployment *appv1.Deployment) (*JobOptions, error) {
+	opts := &JobOptions{
+		Command:          spec.Command,
+		ImageTagOverride: spec.ImageTag,
+		Reason:           spec.Reason,
+		Preset:           spec.Preset,
+		PresetParams:     spec.Params,
+		Env:              spec.Env,
+		Resources:        spec.Resources,
+	}
+	if spec.Deadline != "" {
+		deadline, _ := time.ParseDuration(spec.Deadline)
+		opts.DeadlineSeconds = int64(deadline.Seconds())
+	}
+	if spec.Preset != "" {
+		command, err := renderPreset(deployment, spec.Preset, spec.Params)
+		if err != nil {
+			return nil, err
+		}
+		opts.Command = command
+	}
+	return opts, nil
+}
+
+func getPresets(deployment *appv1.Deployment) (map[string]string, error) {
+	presets := map[string]string{}
+	annotation, ok := deployment.Annotations[PresetsAnnotationKey]
+	if !ok {
+		return presets, nil
+	}
+	if err := json.Unmarshal([]byte(annotation), &presets); err != nil {
+		return nil, fmt.Errorf("invalid presets annotation %s: %s", PresetsAnnotationKey, err.Error())
+	}
+	return presets, nil
+}
+
+// renderPreset replaces the ${param} placeholders of a preset's command. Missing and unused params
+// are both errors, since they're most likely typos.
+func renderPreset(deployment *appv1.Deployment, preset string, params map[string]string) (string, error) {
+	presets, err := getPresets(deployment)
+	if err != nil {
+		return "", err
+	}
+	template, ok := presets[preset]
+	if !ok {
+		names := []string{}
+		for name := range presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("deployment %s/%s has no preset %s, the available presets are: %s", deployment.Namespace, deployment.Name, preset, strings.Join(names, ", "))
+	}
+
+	missing := []string{}
+	used := map[string]bool{}
+	command := presetParamRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := presetParamRegexp.FindStringSubmatch(placeholder)[1]
+		value, ok := params[name]
+		if !ok {
+			missing = append(missing, name)
+			return placeholder
+		}
+		used[name] = true
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("preset %s needs the params: %s", preset, strings.Join(missing, ", "))
+	}
+	for name := range params {
+		if !used[name] {
+			return "", fmt.Errorf("preset %s doesn't use the param %s", preset, name)
+		}
+	}
+	return command, nil
+}
+
+// setupJobOverrides applies the environment and resource overrides to the primary container, and
+// records them along with the preset so that the job can be reproduced.
+func setupJobOverrides(job *batchv1.Job, primaryContainerIndex int, opts *JobOptions) {
+	container := &job.Spec.Template.Spec.Containers[primaryContainerIndex]
+	if len(opts.Env) > 0 {
+		names := []string{}
+		for name := range opts.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			setContainerEnv(container, corev1.EnvVar{Name: name, Value: opts.Env[name]})
+		}
+		data, _ := json.Marshal(opts.Env)
+		job.Annotations[EnvAnnotationKey] = string(data)
+	}
+	if opts.Resources != nil {
+		container.Resources = *opts.Resources
+		data, _ := json.Marshal(opts.Resources)
+		job.Annotations[ResourcesAnnotationKey] = string(data)
+	}
+	if opts.Preset != "" {
+		job.Annotations[PresetAnnotationKey] = opts.Preset
+		data, _ := json.Marshal(opts.PresetParams)
+		job.Annotations[PresetParamsAnnotationKey] = string(data)
+	}
+}
+
+func setContainerEnv(container *corev1.Container, env corev1.EnvVar) {
+	for i := range container.Env {
+		if container.Env[i].Name == env.Name {
+			container.Env[i] = env
+			return
+		}
+	}
+	container.Env = append(container.Env, env)
+}
+
+// getJobOverrides reads the overrides recorded by setupJobOverrides back into the options.
+func getJobOverrides(job *batchv1.Job, opts *JobOptions) {
+	if data, ok := job.Annotations[EnvAnnotationKey]; ok {
+		_ = json.Unmarshal([]byte(data), &opts.Env)
+	}
+	if data, ok := job.Annotations[ResourcesAnnotationKey]; ok {
+		resources := &corev1.ResourceRequirements{}
+		if json.Unmarshal([]byte(data), resources) == nil {
+			opts.Resources = resources
+		}
+	}
+	if preset, ok := job.Annotations[PresetAnnotationKey]; ok {
+		opts.Preset = preset
+		_ = json.Unmarshal([]byte(job.Annotations[PresetParamsAnnotationKey]), &opts.PresetParams)
+	}
+}
+
+func getResourcesString(resources *corev1.ResourceRequirements) string {
+	parts := []string{}
+	for _, r := range []struct {
+		kind string
+		list corev1.ResourceList
+	}{{"requests", resources.Requests}, {"limits", resources.Limits}} {
+		values := []string{}
+		for name, quantity := range r.list {
+			values = append(values, fmt.Sprintf("%s=%s", name, quantity.String()))
+		}
+		sort.Strings(values)
+		if len(values) > 0 {
+			parts = append(parts, fmt.Sprintf("%s %s", r.kind, strings.Join(values, ", ")))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func apply(clientset *kubernetes.Clientset, path string, force, dryRun bool) {
+	spec, err := readTaskSpec(path)
+	if err != nil {
+		fmt.Printf("Error reading spec: %s\n", err.Error())
+		os.Exit(1)
+	}
+	namespace, name := parseJobArgs([]string{spec.Deployment})
+	deployment, err := findDeployment(clientset, namespace, name)
+	if err != nil {
+		fmt.Printf("Error getting deployment: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if err := validateDeployment(deployment); err != nil {
+		fmt.Printf("Invalid deployment: %s\n", err.Error())
+		os.Exit(1)
+	}
+	opts, err := spec.getJobOptions(deployment)
+	if err != nil {
+		fmt.Printf("Invalid spec: %s\n", err.Error())
+		os.Exit(1)
+	}
+	opts.CreatedBy = getCurrentUser(clientset)
+	opts.Force = force
+
+	problems, warnings := getJobChecks(clientset, deployment)(opts)
+	if requiresReason(deployment) && opts.Reason == "" {
+		problems = append(problems, "This deployment requires a reason for every job, set it in the spec")
+	}
+	printConfirmationDetails(deployment, opts, problems, warnings)
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+
+	job := setupJob(deployment, opts)
+	fmt.Println()
+	if dryRun {
+		data, err := yaml.Marshal(job)
+		if err != nil {
+			panic(err.Error())
+		}
+		fmt.Print(string(data))
+		return
+	}
+	submitJob(clientset, deployment, opts, job)
+}
+
+// initTaskSpec writes the spec that reproduces an existing job, to a file or to stdout.
+func initTaskSpec(job *batchv1.Job, path string) {
+	deploymentName, ok := job.Annotations[SourceDeploymentAnnotationKey]
+	if !ok {
+		fmt.Printf("Job %s/%s doesn't have source deployment annotation %s\n", job.Namespace, job.Name, SourceDeploymentAnnotationKey)
+		os.Exit(1)
+	}
+	opts := &JobOptions{}
+	getJobOverrides(job, opts)
+	spec := &TaskSpec{
+		Deployment: job.Namespace + "/" + deploymentName,
+		Preset:     opts.Preset,
+		Params:     opts.PresetParams,
+		ImageTag:   getJobImageTag(job),
+		Env:        opts.Env,
+		Resources:  opts.Resources,
+		Reason:     job.Annotations[ReasonAnnotationKey],
+	}
+	if spec.Preset == "" {
+		spec.Command = job.Annotations[UserCommandAnnotationKey]
+	}
+	if job.Spec.ActiveDeadlineSeconds != nil && *job.Spec.ActiveDeadlineSeconds != DefaultDeadlineSeconds {
+		spec.Deadline = (time.Duration(*job.Spec.ActiveDeadlineSeconds) * time.Second).String()
+	}
+
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		panic(err.Error())
+	}
+	data = append([]byte(fmt.Sprintf("# Created from job %s/%s, apply it with: jobify apply -f <file>\n", job.Namespace, job.Name)), data...)
+	if path == "" {
+		fmt.Print(string(data))
+		return
+	}
+	if _, err := os.Stat(path); err == nil {
+		fmt.Printf("Error writing spec: %s already exists\n", path)
+		os.Exit(1)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Error writing spec: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		fmt.Printf("Error writing spec: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote the spec of job %s/%s to %s\n", job.Namespace, job.Name, path)
+}
@@ -0,0 +1,114 @@
+package jobify
+
+import (
+	"strings"
+	"testing"
+
+	appv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderPreset(t *testing.T) {
+	deployment := &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "web",
+			Annotations: map[string]string{
+				PresetsAnnotationKey: `{"migrate":"rake db:migrate","backfill":"rake backfill[${table},${table}_${batch}]"}`,
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		preset  string
+		params  map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "no params",
+			preset: "migrate",
+			want:   "rake db:migrate",
+		},
+		{
+			name:   "params are replaced everywhere",
+			preset: "backfill",
+			params: map[string]string{"table": "users", "batch": "100"},
+			want:   "rake backfill[users,users_100]",
+		},
+		{
+			name:    "unknown preset",
+			preset:  "seed",
+			wantErr: "deployment default/web has no preset seed, the available presets are: backfill, migrate",
+		},
+		{
+			name:    "missing param",
+			preset:  "backfill",
+			params:  map[string]string{"table": "users"},
+			wantErr: "preset backfill needs the params: batch",
+		},
+		{
+			name:    "unused param",
+			preset:  "migrate",
+			params:  map[string]string{"table": "users"},
+			wantErr: "preset migrate doesn't use the param table",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderPreset(deployment, tt.preset, tt.params)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("renderPreset() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderPreset() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderPreset() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderPresetInvalidAnnotation(t *testing.T) {
+	deployment := &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{PresetsAnnotationKey: `["rake db:migrate"]`},
+		},
+	}
+	if _, err := renderPreset(deployment, "migrate", nil); err == nil {
+		t.Error("renderPreset() succeeded with an invalid presets annotation")
+	}
+}
+
+func TestTaskSpecValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    TaskSpec
+		wantErr string
+	}{
+		{name: "command", spec: TaskSpec{Command: "rake db:migrate", Deadline: "30m"}},
+		{name: "preset", spec: TaskSpec{Preset: "backfill", Params: map[string]string{"table": "users"}}},
+		{name: "command and preset", spec: TaskSpec{Command: "rake db:migrate", Preset: "migrate"}, wantErr: "exactly one of command and preset must be set"},
+		{name: "neither command nor preset", spec: TaskSpec{}, wantErr: "exactly one of command and preset must be set"},
+		{name: "params without preset", spec: TaskSpec{Command: "rake db:migrate", Params: map[string]string{"table": "users"}}, wantErr: "params can only be used with a preset"},
+		{name: "invalid deadline", spec: TaskSpec{Command: "rake db:migrate", Deadline: "1x"}, wantErr: "invalid deadline: "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.validate()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("validate() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)) {
+				t.Fatalf("validate() error = %v, want an error starting with %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package jobify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/fatih/color"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	watchLogTailLines   = 15
+	watchRefreshPeriod  = time.Second
+	watchEventsInterval = 3 * time.Second
+	// Arrow keys are sent as escape sequences, which arrive in a single read
+	keyUp    = "\033[A"
+	keyDown  = "\033[B"
+	keyRight = "\033[C"
+	keyLeft  = "\033[D"
+)
+
+type watchState struct {
+	job         *batchv1.Job
+	podList     *corev1.PodList
+	events      []corev1.Event
+	logs        *logFollower
+	selectedPod int
+	followPod   bool
+}
+
+// watchJob re-renders the details of a job every time it or one of its pods changes, until the job
+// finishes or the user quits.
+func watchJob(clientset *kubernetes.Clientset, job *batchv1.Job) {
+	jobFactory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(job.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fmt.Sprintf("metadata.name=%s", job.Name)
+		}),
+	)
+	podFactory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(job.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = fmt.Sprintf("job-name=%s", job.Name)
+		}),
+	)
+
+	changes := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { notify() },
+		UpdateFunc: func(oldObj, newObj interface{}) { notify() },
+		DeleteFunc: func(obj interface{}) { notify() },
+	}
+
+	jobInformer := jobFactory.Batch().V1().Jobs()
+	jobInformer.Informer().AddEventHandler(handler)
+	podInformer := podFactory.Core().V1().Pods()
+	podInformer.Informer().AddEventHandler(handler)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	jobFactory.Start(stop)
+	podFactory.Start(stop)
+	jobFactory.WaitForCacheSync(stop)
+	podFactory.WaitForCacheSync(stop)
+
+	keys := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	stdin := int(os.Stdin.Fd())
+	if readline.IsTerminal(stdin) {
+		state, err := readline.MakeRaw(stdin)
+		if err == nil {
+			defer readline.Restore(stdin, state)
+			go readKeys(keys, done)
+		}
+	}
+
+	ws := &watchState{job: job, podList: &corev1.PodList{}, followPod: true, logs: &logFollower{}}
+	defer ws.logs.stop()
+	ticker := time.NewTicker(watchRefreshPeriod)
+	defer ticker.Stop()
+	lastEventsRefresh := time.Time{}
+
+	for {
+		if j, err := jobInformer.Lister().Jobs(job.Namespace).Get(job.Name); err == nil {
+			ws.job = j
+		}
+		pods, _ := podInformer.Lister().Pods(job.Namespace).List(labels.Everything())
+		ws.podList = &corev1.PodList{}
+		for _, p := range pods {
+			ws.podList.Items = append(ws.podList.Items, *p)
+		}
+		sort.Slice(ws.podList.Items, func(i, j int) bool {
+			return ws.podList.Items[i].CreationTimestamp.UnixNano() < ws.podList.Items[j].CreationTimestamp.UnixNano()
+		})
+		if ws.followPod || ws.selectedPod >= len(ws.podList.Items) {
+			ws.selectedPod = len(ws.podList.Items) - 1
+		}
+
+		if time.Since(lastEventsRefresh) > watchEventsInterval {
+			if events, err := listJobEvents(clientset, ws.job, ws.podList); err == nil {
+				ws.events = events
+				lastEventsRefresh = time.Now()
+			}
+		}
+		if ws.selectedPod >= 0 {
+			pod := &ws.podList.Items[ws.selectedPod]
+			ws.logs.follow(clientset, pod, ws.job.Annotations[PrimaryContainerAnnotationKey], getLogTailLines(watchLogTailLines), notify)
+		}
+
+		renderWatch(ws)
+
+		completed, failed := checkJobCondition(ws.job)
+		if completed || failed {
+			fmt.Fprint(color.Output, "\r\nThe job has finished, stopped watching.\r\n")
+			return
+		}
+
+		select {
+		case <-changes:
+		case <-ticker.C:
+		case key, ok := <-keys:
+			if !ok || key == "q" || key == "\x03" {
+				fmt.Fprint(color.Output, "\r\nStopped watching.\r\n")
+				return
+			}
+			switch key {
+			case "n", keyRight:
+				if ws.selectedPod < len(ws.podList.Items)-1 {
+					ws.selectedPod++
+				}
+				ws.followPod = ws.selectedPod == len(ws.podList.Items)-1
+			case "p", keyLeft:
+				if ws.selectedPod > 0 {
+					ws.selectedPod--
+				}
+				ws.followPod = false
+			}
+		}
+	}
+}
+
+func renderWatch(ws *watchState) {
+	buf := new(bytes.Buffer)
+	printJobDetails(buf, ws.job, ws.podList, ws.events)
+
+	fmt.Fprintln(buf)
+	fprintAttribute(buf, "Elapsed", getJobElapsedTime(ws.job))
+	if ws.selectedPod >= 0 {
+		pod := ws.podList.Items[ws.selectedPod]
+		fprintAttribute(buf, fmt.Sprintf("Logs of %s (pod %d/%d)", pod.Name, ws.selectedPod+1, len(ws.podList.Items)), "")
+		fmt.Fprint(buf, ws.logs.String())
+	}
+	fmt.Fprintln(buf)
+	faint.Fprintln(buf, "n/→: next pod, p/←: previous pod, q: quit")
+
+	// The terminal is in raw mode, so new lines don't return the cursor to the start of the line
+	output := strings.Replace(buf.String(), "\n", "\r\n", -1)
+	fmt.Fprint(color.Output, "\033[H\033[2J"+output)
+}
+
+// readKeys sends the keys typed in the terminal until done is closed. Escape sequences like the
+// arrow keys are sent as one key, other input one byte at a time.
+func readKeys(keys chan<- string, done <-chan struct{}) {
+	defer close(keys)
+	b := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(b)
+		if err != nil {
+			return
+		}
+		input := b[:n]
+		for len(input) > 0 {
+			key := input[:1]
+			if len(input) >= 3 && input[0] == keyEscape && input[1] == '[' {
+				key = input[:3]
+			}
+			input = input[len(key):]
+			select {
+			case keys <- string(key):
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// logFollower keeps the last lines of a container's logs, following them with a single stream
+// instead of fetching them again and again.
+type logFollower struct {
+	mu     sync.Mutex
+	key    string
+	last   string
+	lines  []string
+	err    error
+	cancel context.CancelFunc
+}
+
+// follow starts following the container, unless it's already followed. A stream that failed, e.g.
+// because the container hasn't started yet, is retried on the next call.
+func (f *logFollower) follow(clientset *kubernetes.Clientset, pod *corev1.Pod, containerName string, tailLines int64, notify func()) {
+	// A restarted container is followed again
+	key := pod.Namespace + "/" + pod.Name + "/" + containerName
+	for _, s := range pod.Status.ContainerStatuses {
+		if s.Name == containerName {
+			key += fmt.Sprintf("/%d", s.RestartCount)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.key == key {
+		return
+	}
+	if f.cancel != nil {
+		f.cancel()
+	}
+	// The error stays shown while a failed stream is retried
+	if f.last != key {
+		f.err = nil
+	}
+	f.lines = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	f.key, f.last, f.cancel = key, key, cancel
+
+	go func() {
+		req := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: containerName,
+			TailLines: &tailLines,
+			Follow:    true,
+		})
+		podLogs, err := req.Stream(ctx)
+		if err == nil {
+			defer podLogs.Close()
+			scanner := bufio.NewScanner(podLogs)
+			for scanner.Scan() {
+				f.mu.Lock()
+				if f.key == key {
+					f.lines = append(f.lines, scanner.Text())
+					if int64(len(f.lines)) > tailLines {
+						f.lines = f.lines[1:]
+					}
+				}
+				f.mu.Unlock()
+				notify()
+			}
+			err = scanner.Err()
+		}
+		if err != nil && ctx.Err() == nil {
+			f.mu.Lock()
+			if f.key == key {
+				f.err = err
+				f.key = ""
+			}
+			f.mu.Unlock()
+			notify()
+		}
+	}()
+}
+
+func (f *logFollower) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.key = ""
+}
+
+func (f *logFollower) String() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil && len(f.lines) == 0 {
+		return fmt.Sprintf("Logs unavailable: %s\n", f.err.Error())
+	}
+	if len(f.lines) == 0 {
+		return ""
+	}
+	return strings.Join(f.lines, "\n") + "\n"
+}
+
+func getJobElapsedTime(job *batchv1.Job) string {
+	if job.Status.StartTime == nil {
+		return "Not started"
+	}
+	end := time.Now()
+	if job.Status.CompletionTime != nil {
+		end = job.Status.CompletionTime.Time
+	} else {
+		for _, c := range job.Status.Conditions {
+			if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+				end = c.LastTransitionTime.Time
+			}
+		}
+	}
+	return duration.HumanDuration(end.Sub(job.Status.StartTime.Time))
+}
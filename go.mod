@@ -3,12 +3,12 @@ module jobify
 go 1.15
 
 require (
+	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
 	github.com/fatih/color v1.10.0
 	github.com/manifoldco/promptui v0.8.0
 	github.com/spf13/cobra v1.1.3
-	k8s.io/api v0.20.4
-	k8s.io/apimachinery v0.20.4
-	k8s.io/client-go v0.20.2
-	k8s.io/utils v0.0.0-20210111153108-fddb29f9d009 // indirect
-
+	k8s.io/api v0.22.17
+	k8s.io/apimachinery v0.22.17
+	k8s.io/client-go v0.22.17
+	sigs.k8s.io/yaml v1.2.0
 )